@@ -0,0 +1,130 @@
+// Command gami-proxy holds one AMI connection per Asterisk and shares it
+// with the AMI clients connecting to the proxy, see package proxy.
+//
+//	gami-proxy -config proxy.json
+//
+// The configuration lists the users allowed to log in to the proxy and,
+// for every Asterisk, the address to listen on for its clients and the
+// addresses of the servers, several for an active/standby pair:
+//
+//	{
+//		"users": {"crm": "secret", "wallboard": "other"},
+//		"upstreams": [
+//			{"listen": ":5039", "addresses": ["10.0.0.1:5038", "10.0.0.2:5038"], "user": "admin", "secret": "root"}
+//		]
+//	}
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/xytis/gami"
+	"github.com/xytis/gami/proxy"
+)
+
+type config struct {
+	Users     map[string]string `json:"users"`
+	Upstreams []upstream        `json:"upstreams"`
+}
+
+type upstream struct {
+	Listen    string   `json:"listen"`
+	Addresses []string `json:"addresses"`
+	User      string   `json:"user"`
+	Secret    string   `json:"secret"`
+}
+
+func main() {
+	path := flag.String("config", "gami-proxy.json", "configuration file")
+	flag.Parse()
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+	cfg, err := load(*path)
+	if err != nil {
+		logger.Error("configuration not loaded", "error", err)
+		os.Exit(1)
+	}
+
+	var servers []*proxy.Server
+	var upstreams []*gami.Failover
+	errs := make(chan error, len(cfg.Upstreams))
+	for _, up := range cfg.Upstreams {
+		fo := gami.NewFailover(up.Addresses, up.User, up.Secret, gami.WithLogger(logger))
+		if err := fo.Connect(); err != nil {
+			logger.Error("upstream not connected", "addresses", up.Addresses, "error", err)
+			os.Exit(1)
+		}
+		srv := proxy.New(fo, cfg.Users)
+		srv.Logger = logger.With("listen", up.Listen)
+		srv.Banner = "Asterisk Call Manager/" + fo.Client().Version()
+		go srv.Forward(fo.Events)
+		go watch(srv.Logger, fo)
+		go func(listen string) {
+			errs <- srv.ListenAndServe(listen)
+		}(up.Listen)
+		servers = append(servers, srv)
+		upstreams = append(upstreams, fo)
+		logger.Info("proxy started", "listen", up.Listen, "upstream", fo.Address())
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	status := 0
+	select {
+	case sig := <-signals:
+		logger.Info("stopping", "signal", sig.String())
+	case err := <-errs:
+		logger.Error("listen failed", "error", err)
+		status = 1
+	}
+
+	for _, srv := range servers {
+		srv.Close()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, fo := range upstreams {
+		fo.Shutdown(ctx)
+	}
+	os.Exit(status)
+}
+
+func load(path string) (*config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &config{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// watch log the errors and switches of an upstream until it's shut down
+func watch(logger *slog.Logger, fo *gami.Failover) {
+	errs, switches := fo.Errors, fo.Switches
+	for errs != nil || switches != nil {
+		select {
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			logger.Warn("upstream error", "error", err)
+		case sw, ok := <-switches:
+			if !ok {
+				switches = nil
+				continue
+			}
+			logger.Warn("upstream failed over", "from", sw.From, "to", sw.To, "error", sw.Err)
+		}
+	}
+}
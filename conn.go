@@ -0,0 +1,84 @@
+package gami
+
+import (
+	"bytes"
+	"strings"
+)
+
+// FrameWriter is implemented by connections able to write a whole action in
+// one call, the client uses it instead of PrintfLine when available so an
+// action is never left half written on the wire
+type FrameWriter interface {
+	WriteFrame(frame []byte) error
+}
+
+// buildFrame serialize an action, headers must be checked before
+func buildFrame(action string, headers Headers) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("Action: " + strings.TrimSpace(action) + "\r\n")
+	for _, header := range headers {
+		buf.WriteString(header.Key + ": " + strings.TrimSpace(header.Value) + "\r\n")
+	}
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
+
+type writeRequest struct {
+	frame []byte
+	errc  chan error
+}
+
+// write send a frame directly or through the batching writer
+func (client *AMIClient) write(frame []byte) error {
+	if client.writes == nil {
+		client.writeLock.Lock()
+		defer client.writeLock.Unlock()
+		return client.writeFrame(frame)
+	}
+	req := writeRequest{frame, make(chan error, 1)}
+	select {
+	case client.writes <- req:
+	case <-client.done:
+		return ErrClosed
+	}
+	return <-req.errc
+}
+
+func (client *AMIClient) writeFrame(frame []byte) error {
+	if fw, ok := client.conn.(FrameWriter); ok {
+		return fw.WriteFrame(frame)
+	}
+	// PrintfLine adds the final CRLF, textproto flushes it in one write
+	return client.conn.PrintfLine("%s", frame[:len(frame)-2])
+}
+
+// writer coalesce the actions queued while the previous write was running
+func (client *AMIClient) writer() {
+	for {
+		var batch []writeRequest
+		select {
+		case req := <-client.writes:
+			batch = append(batch, req)
+		case <-client.done:
+			return
+		}
+	drain:
+		for len(batch) < client.batchSize {
+			select {
+			case req := <-client.writes:
+				batch = append(batch, req)
+			default:
+				break drain
+			}
+		}
+
+		var buf []byte
+		for _, req := range batch {
+			buf = append(buf, req.frame...)
+		}
+		err := client.writeFrame(buf)
+		for _, req := range batch {
+			req.errc <- err
+		}
+	}
+}
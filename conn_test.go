@@ -0,0 +1,129 @@
+package gami
+
+import (
+	"bytes"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// WriteCounter records every Write call, blocking the first one until
+// release is closed when set
+type WriteCounter struct {
+	mu      sync.Mutex
+	writes  [][]byte
+	started chan struct{}
+	release chan struct{}
+}
+
+func (w *WriteCounter) Read(p []byte) (int, error) {
+	select {}
+}
+
+func (w *WriteCounter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	w.writes = append(w.writes, append([]byte(nil), p...))
+	first := len(w.writes) == 1
+	w.mu.Unlock()
+	if first && w.release != nil {
+		close(w.started)
+		<-w.release
+	}
+	return len(p), nil
+}
+
+func (w *WriteCounter) Close() error {
+	return nil
+}
+
+func TestBuildFrame(t *testing.T) {
+	frame := buildFrame(" Ping ", Headers{{"ActionID", " 1 "}})
+	assert.Equal(t, "Action: Ping\r\nActionID: 1\r\n\r\n", string(frame))
+}
+
+func TestActionSingleWrite(t *testing.T) {
+	rwc := &WriteCounter{}
+	client, _ := newRecordClient()
+	client.conn = NewStreamTransport(rwc)
+
+	_, err := client.AsyncAction("Originate", Params{"Channel": "SIP/100", "Exten": "200", "ActionID": "1"})
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, len(rwc.writes))
+	assert.Equal(t, "Action: Originate\r\nActionID: 1\r\nChannel: SIP/100\r\nExten: 200\r\n\r\n", string(rwc.writes[0]))
+}
+
+func TestActionPrintfLineFallback(t *testing.T) {
+	client, mock := newRecordClient()
+
+	_, err := client.AsyncAction("Ping", Params{"ActionID": "1"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Action: Ping", "ActionID: 1", ""}, mock.lines)
+}
+
+func TestWriteTimeout(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	conn := NewStreamTransport(local)
+	conn.SetWriteTimeout(10 * time.Millisecond)
+
+	// nobody reads the other end of the pipe
+	err := conn.WriteFrame([]byte("Action: Ping\r\n\r\n"))
+	netErr, ok := err.(net.Error)
+	assert.True(t, ok && netErr.Timeout(), err)
+}
+
+func TestBatching(t *testing.T) {
+	rwc := &WriteCounter{started: make(chan struct{}), release: make(chan struct{})}
+	client := &AMIClient{
+		conn:      NewStreamTransport(rwc),
+		opPrefix:  "r",
+		raw:       make(chan textproto.MIMEHeader),
+		response:  make(map[string]chan *AMIResponse),
+		batchSize: 10,
+		writes:    make(chan writeRequest),
+		done:      make(chan struct{}),
+	}
+	go client.writer()
+	defer close(client.done)
+
+	var wg sync.WaitGroup
+	send := func() {
+		defer wg.Done()
+		_, err := client.AsyncAction("Ping", nil)
+		assert.NoError(t, err)
+	}
+
+	// the first write blocks, the next actions queue behind it
+	wg.Add(1)
+	go send()
+	<-rwc.started
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go send()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(rwc.release)
+	wg.Wait()
+
+	assert.Equal(t, 2, len(rwc.writes))
+	all := bytes.Join(rwc.writes, nil)
+	assert.Equal(t, 6, strings.Count(string(all), "Action: Ping\r\n"))
+	assert.Equal(t, 5, strings.Count(string(rwc.writes[1]), "\r\n\r\n"))
+}
+
+func TestBatchingClosed(t *testing.T) {
+	client, _ := newRecordClient()
+	client.writes = make(chan writeRequest)
+	client.done = make(chan struct{})
+	close(client.done)
+
+	_, err := client.AsyncAction("Ping", nil)
+	assert.Equal(t, ErrClosed, err)
+	assert.Equal(t, 0, len(client.response))
+}
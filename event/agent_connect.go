@@ -1,8 +1,11 @@
 // Package event for AMI
 package event
 
+import "github.com/xytis/gami"
+
 // AgentConnect triggered when an agent connects.
 type AgentConnect struct {
+	gami.EventMeta
 	Privilege      []string
 	HoldTime       string `AMI:"Holdtime"`
 	BridgedChannel string `AMI:"Bridgedchannel"`
@@ -1,8 +1,11 @@
 // Package event for AMI
 package event
 
+import "github.com/xytis/gami"
+
 // AgentLogin trigger when agent logs in
 type AgentLogin struct {
+	gami.EventMeta
 	Privilege []string
 	Agent     string `AMI:"Agent"`
 	UniqueID  string `AMI:"Uniqueid"`
@@ -1,8 +1,11 @@
 // Package event for AMI
 package event
 
+import "github.com/xytis/gami"
+
 // AgentLogoff triggered when an agent logs off.
 type AgentLogoff struct {
+	gami.EventMeta
 	Privilege []string
 	Agent     string `AMI:"Agent"`
 	UniqueID  string `AMI:"Uniqueid"`
@@ -1,8 +1,11 @@
 // Package event for AMI
 package event
 
+import "github.com/xytis/gami"
+
 //Agents trigger for agents
 type Agents struct {
+	gami.EventMeta
 	Privilege        []string
 	Status           string `AMI:"Status"`
 	Agent            string `AMI:"Agent"`
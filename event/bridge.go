@@ -1,7 +1,10 @@
 // Package event for AMI
 package event
 
+import "github.com/xytis/gami"
+
 type Bridge struct {
+	gami.EventMeta
 	Privilege   []string
 	BridgeState string `AMI:"Bridgestate"`
 	BridgeType  string `AMI:"Bridgetype"`
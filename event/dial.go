@@ -1,8 +1,11 @@
 // Package event for AMI
 package event
 
+import "github.com/xytis/gami"
+
 // Dial triggered when a dial is executed.
 type Dial struct {
+	gami.EventMeta
 	Privilege    []string
 	SubEvent     string `AMI:"Subevent"`
 	Channel      string `AMI:"Channel"`
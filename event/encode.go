@@ -0,0 +1,103 @@
+// Package event for AMI
+package event
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+
+	"github.com/xytis/gami"
+)
+
+// ErrUnknownEvent raised when encoding a value that is not a registered event type
+var ErrUnknownEvent = errors.New("Unknown event type")
+
+// Encode build the AMIEvent from a typed event, this is the inverse of New.
+// Raw AMIEvent values are returned as a copy.
+func Encode(event interface{}) (*gami.AMIEvent, error) {
+	switch ev := event.(type) {
+	case gami.AMIEvent:
+		return copyEvent(&ev), nil
+	case *gami.AMIEvent:
+		return copyEvent(ev), nil
+	}
+
+	value := reflect.Indirect(reflect.ValueOf(event))
+	if !value.IsValid() {
+		return nil, ErrUnknownEvent
+	}
+	ev := &gami.AMIEvent{Params: make(gami.Params)}
+	if name, ok := nameOf(value.Type()); ok {
+		ev.ID = name
+	} else if name, ok := userEventNameOf(value.Type()); ok {
+		ev.ID = "UserEvent"
+		ev.Params["Userevent"] = name
+	} else {
+		return nil, ErrUnknownEvent
+	}
+
+	typ := value.Type()
+	for ix := 0; ix < value.NumField(); ix++ {
+		field := value.Field(ix)
+		tfield := typ.Field(ix)
+
+		if tfield.Name == "Privilege" {
+			ev.Privilege = append([]string(nil), field.Interface().([]string)...)
+			continue
+		}
+		if tfield.Type == metaType {
+			ev.EventMeta = field.Interface().(gami.EventMeta)
+			continue
+		}
+		tag := tfield.Tag.Get("AMI")
+		if tag == "" {
+			continue
+		}
+		switch field.Kind() {
+		case reflect.String:
+			ev.Params[tag] = field.String()
+		case reflect.Int64:
+			ev.Params[tag] = strconv.FormatInt(field.Int(), 10)
+		}
+	}
+	return ev, nil
+}
+
+// MarshalAMI encode a typed event into the wire format used by AMI
+func MarshalAMI(event interface{}) ([]byte, error) {
+	ev, err := Encode(event)
+	if err != nil {
+		return nil, err
+	}
+	return ev.MarshalAMI(), nil
+}
+
+// nameOf lookup the event name registered for the type
+func nameOf(typ reflect.Type) (string, bool) {
+	if typ.Kind() != reflect.Struct {
+		return "", false
+	}
+	trapLock.RLock()
+	defer trapLock.RUnlock()
+	return firstName(eventTrap, eventNames, typ)
+}
+
+func copyEvent(event *gami.AMIEvent) *gami.AMIEvent {
+	ev := &gami.AMIEvent{
+		ID:        event.ID,
+		Privilege: append([]string(nil), event.Privilege...),
+		Params:    make(gami.Params, len(event.Params)),
+		EventMeta: event.EventMeta,
+	}
+	for k, v := range event.Params {
+		ev.Params[k] = v
+	}
+	return ev
+}
+
+// userEventNameOf lookup the UserEvent name registered for the type
+func userEventNameOf(typ reflect.Type) (string, bool) {
+	trapLock.RLock()
+	defer trapLock.RUnlock()
+	return firstName(userEventTrap, userEventNames, typ)
+}
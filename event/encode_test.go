@@ -0,0 +1,106 @@
+package event
+
+import (
+	"bufio"
+	"bytes"
+	"net/textproto"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xytis/gami"
+)
+
+func TestEncodeHangup(t *testing.T) {
+	hangup := Hangup{
+		Privilege:    []string{"call", "all"},
+		Channel:      "SIP/100-00000001",
+		CallerIDNum:  "100",
+		CallerIDName: "Alice",
+		UniqueID:     "1400000000.1",
+		Cause:        "16",
+		CauseText:    "Normal Clearing",
+	}
+
+	ev, err := Encode(hangup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.ID != "Hangup" {
+		t.Fatal("Encode event ID:", ev.ID)
+	}
+	if ev.Params["Cause-Text"] != "Normal Clearing" {
+		t.Fatal("Encode field Cause-Text:", ev.Params)
+	}
+
+	if !reflect.DeepEqual(New(ev), hangup) {
+		t.Fatal("Encode round trip:", New(ev))
+	}
+}
+
+func TestEncodeInt64(t *testing.T) {
+	stats := RTPReceiverStats{SSRC: "1", ReceivedPackets: 42, LostPackets: 3}
+	ev, err := Encode(&stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Params["Receivedpackets"] != "42" || ev.Params["Lostpackets"] != "3" {
+		t.Fatal("Encode int64 fields:", ev.Params)
+	}
+}
+
+func TestEncodeUnknown(t *testing.T) {
+	type NotAnEvent struct{ A string }
+	if _, err := Encode(NotAnEvent{}); err != ErrUnknownEvent {
+		t.Fatal("Encode unknown type:", err)
+	}
+}
+
+func TestMarshalAMI(t *testing.T) {
+	data, err := MarshalAMI(UserEvent{Privilege: []string{"user", "all"}, UserEvent: "Test", UniqueID: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "Event: UserEvent\r\nPrivilege: user,all\r\nUniqueid: 1\r\nUserevent: Test\r\n\r\n"
+	if string(data) != expected {
+		t.Fatalf("MarshalAMI: %q", data)
+	}
+
+	header, err := textproto.NewReader(bufio.NewReader(bytes.NewReader(data))).ReadMIMEHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Get("Userevent") != "Test" {
+		t.Fatal("MarshalAMI not readable:", header)
+	}
+}
+
+func TestEncodeRaw(t *testing.T) {
+	raw := gami.AMIEvent{ID: "Custom", Params: gami.Params{"A": "B"}}
+	ev, err := Encode(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev.Params["A"] = "C"
+	if raw.Params["A"] != "B" {
+		t.Fatal("Encode must copy raw events")
+	}
+}
+
+func TestEncodeMeta(t *testing.T) {
+	meta := gami.EventMeta{Received: time.Now(), Sequence: 9, Session: "abc"}
+	ev := gami.AMIEvent{ID: "Hangup", Params: gami.Params{"Channel": "SIP/100"}, EventMeta: meta}
+
+	hangup, ok := New(&ev).(Hangup)
+	if !ok || hangup.Sequence != 9 || hangup.Session != "abc" {
+		t.Fatal("New must copy the event meta:", hangup)
+	}
+
+	encoded, err := Encode(hangup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encoded.EventMeta != meta {
+		t.Fatal("Encode must copy the event meta:", encoded.EventMeta)
+	}
+}
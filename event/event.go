@@ -3,9 +3,11 @@
 package event
 
 import (
-	"fmt"
+	"log/slog"
+	"net/textproto"
 	"reflect"
 	"strconv"
+	"sync/atomic"
 
 	"github.com/xytis/gami"
 )
@@ -13,9 +15,34 @@ import (
 // eventTrap used internal for trap events and cast
 var eventTrap = make(map[string]interface{})
 
+// logger used by the decoder, see SetLogger
+var logger atomic.Pointer[slog.Logger]
+
+// SetLogger set the logger of the decoder, nil disables logging
+func SetLogger(l *slog.Logger) {
+	logger.Store(l)
+}
+
+func log() *slog.Logger {
+	if l := logger.Load(); l != nil {
+		return l
+	}
+	return discard
+}
+
+var discard = slog.New(slog.DiscardHandler)
+
+// metaType is embedded by every event type
+var metaType = reflect.TypeOf(gami.EventMeta{})
+
 //New build a new event Type if not return the AMIEvent
 func New(event *gami.AMIEvent) interface{} {
-	if intf, ok := eventTrap[event.ID]; ok {
+	if event.ID == "UserEvent" {
+		if intf, ok := lookupUserEvent(event.Params["Userevent"]); ok {
+			return build(event, &intf)
+		}
+	}
+	if intf, ok := lookup(event.ID); ok {
 		return build(event, &intf)
 	}
 	return *event
@@ -34,16 +61,33 @@ func build(event *gami.AMIEvent, klass *interface{}) interface{} {
 			field.Set(reflect.ValueOf(event.Privilege))
 			continue
 		}
+		if tfield.Type == metaType {
+			field.Set(reflect.ValueOf(event.EventMeta))
+			continue
+		}
 		switch field.Kind() {
 		case reflect.String:
-			field.SetString(event.Params[tfield.Tag.Get("AMI")])
+			field.SetString(param(event, tfield.Tag.Get("AMI")))
 		case reflect.Int64:
-			vint, _ := strconv.Atoi(event.Params[tfield.Tag.Get("AMI")])
+			value := param(event, tfield.Tag.Get("AMI"))
+			vint, err := strconv.Atoi(value)
+			if err != nil && value != "" {
+				log().Warn("event field not decoded", "event", event.ID, "field", tfield.Name, "value", value, "error", err)
+			}
 			field.SetInt(int64(vint))
 		default:
-			fmt.Print(ix, tfield.Tag.Get("AMI"), ":", field, "\n")
+			log().Warn("event field not decoded", "event", event.ID, "field", tfield.Name, "kind", field.Kind().String())
 		}
 
 	}
 	return ret.Interface()
 }
+
+// param lookup the value for an AMI tag, falling back to the canonical form
+// the reader uses for header keys
+func param(event *gami.AMIEvent, key string) string {
+	if v, ok := event.Params[key]; ok {
+		return v
+	}
+	return event.Params[textproto.CanonicalMIMEHeaderKey(key)]
+}
@@ -0,0 +1,25 @@
+package event
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/xytis/gami"
+)
+
+func TestDecodeLogger(t *testing.T) {
+	var out bytes.Buffer
+	SetLogger(slog.New(slog.NewTextHandler(&out, nil)))
+	defer SetLogger(nil)
+
+	ev := gami.AMIEvent{ID: "RTPReceiverStats", Params: gami.Params{"Receivedpackets": "many"}}
+	stats := New(&ev).(RTPReceiverStats)
+	if stats.ReceivedPackets != 0 {
+		t.Fatal("Invalid int64 field decoded:", stats.ReceivedPackets)
+	}
+	if !strings.Contains(out.String(), "event=RTPReceiverStats field=ReceivedPackets value=many") {
+		t.Fatal("Decode failure not logged:", out.String())
+	}
+}
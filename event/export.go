@@ -0,0 +1,217 @@
+// Package event for AMI
+package event
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/xytis/gami"
+)
+
+// Envelope wraps an event with the metadata needed to export it to
+// downstream pipelines.
+//
+// The JSON form is stable:
+//
+//	{
+//	  "event":     "Hangup",
+//	  "privilege": ["call", "all"],
+//	  "received":  "2015-01-02T15:04:05.999999999Z",
+//	  "server":    "pbx1",
+//	  "sequence":  42,
+//	  "session":   "9f86d081884c7d65",
+//	  "timestamp": "2015-01-02T15:04:05.123456Z",
+//	  "fields":    {"Channel": "SIP/100-00000001", "Cause": "16", ...}
+//	}
+//
+// For typed events fields are keyed by the Go field name and keep their Go
+// type (int64 fields are numbers), registered user events also carry their
+// name in the UserEvent field. For events without a registered type fields
+// holds the raw AMI params. Sequence, session and timestamp are
+// omitted when unknown.
+type Envelope struct {
+	Event     string
+	Privilege []string
+	Received  time.Time
+	Server    string
+	Sequence  uint64
+	Session   string
+	Timestamp time.Time
+	// Payload is the value returned by New: a typed event, or a gami.AMIEvent
+	// when the event has no registered type.
+	Payload interface{}
+}
+
+type jsonEnvelope struct {
+	Event     string          `json:"event"`
+	Privilege []string        `json:"privilege,omitempty"`
+	Received  time.Time       `json:"received"`
+	Server    string          `json:"server,omitempty"`
+	Sequence  uint64          `json:"sequence,omitempty"`
+	Session   string          `json:"session,omitempty"`
+	Timestamp *time.Time      `json:"timestamp,omitempty"`
+	Fields    json.RawMessage `json:"fields"`
+}
+
+// NewEnvelope wrap a raw or typed event received from server at the given
+// time, a zero received time or an empty server is taken from the event
+// itself
+func NewEnvelope(event interface{}, server string, received time.Time) (*Envelope, error) {
+	ev, err := Encode(event)
+	if err != nil {
+		return nil, err
+	}
+	if received.IsZero() {
+		received = ev.Received
+	}
+	if server == "" {
+		server = ev.Server
+	}
+	return &Envelope{
+		Event:     ev.ID,
+		Privilege: ev.Privilege,
+		Received:  received,
+		Server:    server,
+		Sequence:  ev.Sequence,
+		Session:   ev.Session,
+		Timestamp: ev.Timestamp,
+		Payload:   New(ev),
+	}, nil
+}
+
+// meta build the EventMeta carried by the payload
+func (env *Envelope) meta() gami.EventMeta {
+	return gami.EventMeta{
+		Received:  env.Received,
+		Sequence:  env.Sequence,
+		Session:   env.Session,
+		Timestamp: env.Timestamp,
+	}
+}
+
+// MarshalJSON implements json.Marshaler
+func (env *Envelope) MarshalJSON() ([]byte, error) {
+	fields := make(map[string]interface{})
+	switch payload := env.Payload.(type) {
+	case gami.AMIEvent:
+		for k, v := range payload.Params {
+			fields[k] = v
+		}
+	case nil:
+	default:
+		value := reflect.Indirect(reflect.ValueOf(payload))
+		if name, ok := userEventNameOf(value.Type()); ok {
+			fields["UserEvent"] = name
+		}
+		for _, ix := range fieldsOf(value.Type()) {
+			fields[value.Type().Field(ix).Name] = value.Field(ix).Interface()
+		}
+	}
+	raw, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	aux := jsonEnvelope{
+		Event:     env.Event,
+		Privilege: env.Privilege,
+		Received:  env.Received,
+		Server:    env.Server,
+		Sequence:  env.Sequence,
+		Session:   env.Session,
+		Fields:    raw,
+	}
+	if !env.Timestamp.IsZero() {
+		aux.Timestamp = &env.Timestamp
+	}
+	return json.Marshal(aux)
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (env *Envelope) UnmarshalJSON(data []byte) error {
+	var aux jsonEnvelope
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	env.Event = aux.Event
+	env.Privilege = aux.Privilege
+	env.Received = aux.Received
+	env.Server = aux.Server
+	env.Sequence = aux.Sequence
+	env.Session = aux.Session
+	env.Timestamp = time.Time{}
+	if aux.Timestamp != nil {
+		env.Timestamp = *aux.Timestamp
+	}
+
+	klass, ok := lookup(aux.Event)
+	if !ok {
+		params := make(gami.Params)
+		if len(aux.Fields) > 0 {
+			if err := json.Unmarshal(aux.Fields, &params); err != nil {
+				return err
+			}
+		}
+		env.Payload = gami.AMIEvent{ID: aux.Event, Privilege: aux.Privilege, Params: params, EventMeta: env.meta()}
+		return nil
+	}
+
+	fields := make(map[string]json.RawMessage)
+	if len(aux.Fields) > 0 {
+		if err := json.Unmarshal(aux.Fields, &fields); err != nil {
+			return err
+		}
+	}
+	if aux.Event == "UserEvent" {
+		var name string
+		json.Unmarshal(fields["UserEvent"], &name)
+		if intf, ok := lookupUserEvent(name); ok {
+			klass = intf
+		}
+	}
+	typ := reflect.TypeOf(klass)
+	ret := reflect.New(typ).Elem()
+	setPrivilege(ret, aux.Privilege)
+	setMeta(ret, env.meta())
+	for _, ix := range fieldsOf(typ) {
+		raw, ok := fields[typ.Field(ix).Name]
+		if !ok {
+			continue
+		}
+		if err := json.Unmarshal(raw, ret.Field(ix).Addr().Interface()); err != nil {
+			return err
+		}
+	}
+	env.Payload = ret.Interface()
+	return nil
+}
+
+// fieldsOf return the index of the AMI fields of an event type
+func fieldsOf(typ reflect.Type) []int {
+	var index []int
+	for ix := 0; ix < typ.NumField(); ix++ {
+		tfield := typ.Field(ix)
+		if tfield.Name == "Privilege" || tfield.Tag.Get("AMI") == "" {
+			continue
+		}
+		switch tfield.Type.Kind() {
+		case reflect.String, reflect.Int64:
+			index = append(index, ix)
+		}
+	}
+	return index
+}
+
+func setMeta(value reflect.Value, meta gami.EventMeta) {
+	for ix := 0; ix < value.NumField(); ix++ {
+		if value.Type().Field(ix).Type == metaType {
+			value.Field(ix).Set(reflect.ValueOf(meta))
+		}
+	}
+}
+
+func setPrivilege(value reflect.Value, privilege []string) {
+	if field := value.FieldByName("Privilege"); field.IsValid() && privilege != nil {
+		field.Set(reflect.ValueOf(privilege))
+	}
+}
@@ -0,0 +1,76 @@
+package event
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xytis/gami"
+)
+
+func TestEnvelopeJSON(t *testing.T) {
+	received := time.Date(2015, 1, 2, 15, 4, 5, 6, time.UTC)
+	stats := RTPReceiverStats{
+		EventMeta:       gami.EventMeta{Received: received, Sequence: 7, Session: "abc"},
+		Privilege:       []string{"reporting", "all"},
+		SSRC:            "1234",
+		ReceivedPackets: 42,
+		Jitter:          "0.01",
+	}
+	env, err := NewEnvelope(stats, "pbx1", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`"event":"RTPReceiverStats"`,
+		`"privilege":["reporting","all"]`,
+		`"received":"2015-01-02T15:04:05.000000006Z"`,
+		`"server":"pbx1"`,
+		`"sequence":7`,
+		`"session":"abc"`,
+		`"ReceivedPackets":42`,
+		`"SSRC":"1234"`,
+	} {
+		if !strings.Contains(string(data), expected) {
+			t.Fatal("Envelope JSON missing", expected, "in", string(data))
+		}
+	}
+
+	var decoded Envelope
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.Payload, stats) {
+		t.Fatal("Envelope JSON round trip:", decoded.Payload)
+	}
+	if !decoded.Received.Equal(received) || decoded.Server != "pbx1" {
+		t.Fatal("Envelope JSON metadata:", decoded)
+	}
+}
+
+func TestEnvelopeJSONRaw(t *testing.T) {
+	raw := gami.AMIEvent{ID: "NotTyped", Privilege: []string{"all"}, Params: gami.Params{"Foo": "Bar"}}
+	env, err := NewEnvelope(&raw, "", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded Envelope
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.Payload, raw) {
+		t.Fatal("Envelope JSON raw round trip:", decoded.Payload)
+	}
+}
@@ -1,8 +1,11 @@
 // Package event for AMI
 package event
 
+import "github.com/xytis/gami"
+
 // ExtensionStatus triggered when an extension changes its status.
 type ExtensionStatus struct {
+	gami.EventMeta
 	Privilege []string
 	Extension string `AMI:"Exten"`
 	Context   string `AMI:"Context"`
@@ -1,8 +1,11 @@
 // Package event for AMI
 package event
 
+import "github.com/xytis/gami"
+
 // Raised when all Asterisk initialization procedures have finished.
 type FullyBooted struct {
+	gami.EventMeta
 	Privilege []string
 	Status    string `AMI:"Status"`
 }
@@ -1,8 +1,11 @@
 // Package event for AMI
 package event
 
+import "github.com/xytis/gami"
+
 // Hangup triggered when a hangup is detected.
 type Hangup struct {
+	gami.EventMeta
 	Privilege    []string
 	Channel      string `AMI:"Channel"`
 	CallerIDNum  string `AMI:"Calleridnum"`
@@ -1,8 +1,11 @@
 // Package event for AMI
 package event
 
+import "github.com/xytis/gami"
+
 // Raised when a channel joins a Queue.
 type Join struct {
+	gami.EventMeta
 	Privilege         []string
 	Queue             string `AMI:"Queue"`
 	Position          string `AMI:"Position"`
@@ -1,8 +1,11 @@
 // Package event for AMI
 package event
 
+import "github.com/xytis/gami"
+
 // Raised when a channel leaves a Queue.
 type Leave struct {
+	gami.EventMeta
 	Privilege []string
 	Queue     string `AMI:"Queue"`
 	Count     string `AMI:"Count"`
@@ -1,8 +1,11 @@
 // Package event for AMI
 package event
 
+import "github.com/xytis/gami"
+
 // Raised when a masquerade occurs between two channels, wherein the Clone channel's internal information replaces the Original channel's information.
 type Masquerade struct {
+	gami.EventMeta
 	Privilege     []string
 	Clone         string `AMI:"Clone"`
 	CloneState    string `AMI:"CloneState"`
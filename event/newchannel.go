@@ -1,8 +1,11 @@
 // Package event for AMI
 package event
 
+import "github.com/xytis/gami"
+
 // Newchannel triggered when a new channel is created.
 type Newchannel struct {
+	gami.EventMeta
 	Privilege        []string
 	Channel          string `AMI:"Channel"`
 	ChannelState     string `AMI:"Channelstate"`
@@ -1,8 +1,11 @@
 // Package event for AMI
 package event
 
+import "github.com/xytis/gami"
+
 // Newexten triggered when a new extension is accessed.
 type Newexten struct {
+	gami.EventMeta
 	Privilege       []string
 	Channel         string `AMI:"Channel"`
 	Extension       string `AMI:"Extension"`
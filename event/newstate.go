@@ -1,8 +1,11 @@
 // Package event for AMI
 package event
 
+import "github.com/xytis/gami"
+
 // Newstate triggered when a channel changes its status.
 type Newstate struct {
+	gami.EventMeta
 	Privilege         []string
 	Channel           string `AMI:"Channel"`
 	ChannelState      string `AMI:"Channelstate"`
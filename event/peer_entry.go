@@ -1,8 +1,11 @@
 // Package event for AMI
 package event
 
+import "github.com/xytis/gami"
+
 // PeerEntry triggered for each peer when an action Sippeers is issued.
 type PeerEntry struct {
+	gami.EventMeta
 	Privilege         []string
 	ChannelType       string `AMI:"Channeltype"`
 	ObjectName        string `AMI:"Objectname"`
@@ -1,8 +1,11 @@
 // Package event for AMI
 package event
 
+import "github.com/xytis/gami"
+
 // PeerStatus trigger when a peers change status
 type PeerStatus struct {
+	gami.EventMeta
 	Privilege   []string
 	ChannelType string `AMI:"Channeltype"`
 	Peer        string `AMI:"Peer"`
@@ -0,0 +1,305 @@
+// Package event for AMI
+package event
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/xytis/gami"
+)
+
+// ErrProto raised when decoding a malformed protobuf message
+var ErrProto = errors.New("Malformed protobuf message")
+
+// Envelope field numbers, see ProtoSchema
+const (
+	protoEvent     = 1
+	protoPrivilege = 2
+	protoReceived  = 3
+	protoServer    = 4
+	protoFields    = 5
+	protoParams    = 6
+	protoSequence  = 7
+	protoSession   = 8
+	protoTimestamp = 9
+)
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// ProtoSchema generate the protobuf schema of Envelope and every registered
+// event type. Event fields are numbered in declaration order of the AMI
+// tagged fields, so new fields must be appended to keep the schema compatible.
+func ProtoSchema() string {
+	var buf bytes.Buffer
+	buf.WriteString("// Code generated by event.ProtoSchema. DO NOT EDIT.\n\n")
+	buf.WriteString("syntax = \"proto3\";\n\npackage gami.event;\n\n")
+	buf.WriteString("// Envelope wraps any AMI event.\n")
+	buf.WriteString("message Envelope {\n")
+	buf.WriteString("  string event = 1;\n")
+	buf.WriteString("  repeated string privilege = 2;\n")
+	buf.WriteString("  int64 received_unix_nano = 3;\n")
+	buf.WriteString("  string server = 4;\n")
+	buf.WriteString("  // Encoded message named by event, when the event has a registered type.\n")
+	buf.WriteString("  bytes fields = 5;\n")
+	buf.WriteString("  // Raw AMI params, when the event has no registered type or is a user event.\n")
+	buf.WriteString("  map<string, string> params = 6;\n")
+	buf.WriteString("  uint64 sequence = 7;\n")
+	buf.WriteString("  string session = 8;\n")
+	buf.WriteString("  int64 timestamp_unix_nano = 9;\n")
+	buf.WriteString("}\n")
+
+	trapLock.RLock()
+	defer trapLock.RUnlock()
+	names := make([]string, 0, len(eventTrap))
+	for name := range eventTrap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		typ := reflect.TypeOf(eventTrap[name])
+		fmt.Fprintf(&buf, "\nmessage %s {\n", name)
+		for n, ix := range fieldsOf(typ) {
+			tfield := typ.Field(ix)
+			kind := "string"
+			if tfield.Type.Kind() == reflect.Int64 {
+				kind = "int64"
+			}
+			fmt.Fprintf(&buf, "  %s %s = %d;\n", kind, snakeCase(tfield.Name), n+1)
+		}
+		buf.WriteString("}\n")
+	}
+	return buf.String()
+}
+
+// MarshalProto encode the envelope as the Envelope protobuf message
+func (env *Envelope) MarshalProto() ([]byte, error) {
+	var buf []byte
+	buf = appendString(buf, protoEvent, env.Event)
+	for _, p := range env.Privilege {
+		buf = appendString(buf, protoPrivilege, p)
+	}
+	if !env.Received.IsZero() {
+		buf = appendVarint(buf, protoReceived, uint64(env.Received.UnixNano()))
+	}
+	buf = appendString(buf, protoServer, env.Server)
+	if env.Sequence != 0 {
+		buf = appendVarint(buf, protoSequence, env.Sequence)
+	}
+	buf = appendString(buf, protoSession, env.Session)
+	if !env.Timestamp.IsZero() {
+		buf = appendVarint(buf, protoTimestamp, uint64(env.Timestamp.UnixNano()))
+	}
+
+	payload := env.Payload
+	if payload != nil {
+		if _, ok := userEventNameOf(reflect.Indirect(reflect.ValueOf(payload)).Type()); ok {
+			// user events are not part of the schema, send them as params
+			ev, err := Encode(payload)
+			if err != nil {
+				return nil, err
+			}
+			payload = *ev
+		}
+	}
+	switch payload := payload.(type) {
+	case gami.AMIEvent:
+		keys := make([]string, 0, len(payload.Params))
+		for k := range payload.Params {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			var entry []byte
+			entry = appendString(entry, 1, k)
+			entry = appendString(entry, 2, payload.Params[k])
+			buf = appendBytes(buf, protoParams, entry)
+		}
+	case nil:
+	default:
+		value := reflect.Indirect(reflect.ValueOf(payload))
+		var fields []byte
+		for n, ix := range fieldsOf(value.Type()) {
+			field := value.Field(ix)
+			if field.Kind() == reflect.Int64 {
+				if field.Int() != 0 {
+					fields = appendVarint(fields, n+1, uint64(field.Int()))
+				}
+			} else {
+				fields = appendString(fields, n+1, field.String())
+			}
+		}
+		buf = appendBytes(buf, protoFields, fields)
+	}
+	return buf, nil
+}
+
+// UnmarshalProto decode an Envelope protobuf message
+func (env *Envelope) UnmarshalProto(data []byte) error {
+	*env = Envelope{}
+	var fields []byte
+	var hasFields bool
+	params := make(gami.Params)
+	err := walkProto(data, func(num int, wire int, v uint64, b []byte) error {
+		switch {
+		case num == protoEvent && wire == wireBytes:
+			env.Event = string(b)
+		case num == protoPrivilege && wire == wireBytes:
+			env.Privilege = append(env.Privilege, string(b))
+		case num == protoReceived && wire == wireVarint:
+			env.Received = time.Unix(0, int64(v)).UTC()
+		case num == protoServer && wire == wireBytes:
+			env.Server = string(b)
+		case num == protoSequence && wire == wireVarint:
+			env.Sequence = v
+		case num == protoSession && wire == wireBytes:
+			env.Session = string(b)
+		case num == protoTimestamp && wire == wireVarint:
+			env.Timestamp = time.Unix(0, int64(v)).UTC()
+		case num == protoFields && wire == wireBytes:
+			fields, hasFields = b, true
+		case num == protoParams && wire == wireBytes:
+			var key, value string
+			err := walkProto(b, func(num int, wire int, v uint64, b []byte) error {
+				switch {
+				case num == 1 && wire == wireBytes:
+					key = string(b)
+				case num == 2 && wire == wireBytes:
+					value = string(b)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			params[key] = value
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	klass, ok := lookup(env.Event)
+	if !ok || (!hasFields && len(params) > 0) {
+		env.Payload = New(&gami.AMIEvent{ID: env.Event, Privilege: env.Privilege, Params: params, EventMeta: env.meta()})
+		return nil
+	}
+
+	typ := reflect.TypeOf(klass)
+	ret := reflect.New(typ).Elem()
+	setPrivilege(ret, env.Privilege)
+	setMeta(ret, env.meta())
+	index := fieldsOf(typ)
+	err = walkProto(fields, func(num int, wire int, v uint64, b []byte) error {
+		if num < 1 || num > len(index) {
+			return nil
+		}
+		field := ret.Field(index[num-1])
+		switch {
+		case field.Kind() == reflect.String && wire == wireBytes:
+			field.SetString(string(b))
+		case field.Kind() == reflect.Int64 && wire == wireVarint:
+			field.SetInt(int64(v))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	env.Payload = ret.Interface()
+	return nil
+}
+
+func appendTag(buf []byte, num int, wire int) []byte {
+	return binary.AppendUvarint(buf, uint64(num)<<3|uint64(wire))
+}
+
+func appendVarint(buf []byte, num int, v uint64) []byte {
+	return binary.AppendUvarint(appendTag(buf, num, wireVarint), v)
+}
+
+func appendBytes(buf []byte, num int, b []byte) []byte {
+	buf = binary.AppendUvarint(appendTag(buf, num, wireBytes), uint64(len(b)))
+	return append(buf, b...)
+}
+
+func appendString(buf []byte, num int, s string) []byte {
+	if s == "" {
+		return buf
+	}
+	return appendBytes(buf, num, []byte(s))
+}
+
+// walkProto call fn for every field of a protobuf message, unknown wire
+// types are rejected
+func walkProto(data []byte, fn func(num int, wire int, v uint64, b []byte) error) error {
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 {
+			return ErrProto
+		}
+		data = data[n:]
+		num, wire := int(tag>>3), int(tag&7)
+		var v uint64
+		var b []byte
+		switch wire {
+		case wireVarint:
+			if v, n = binary.Uvarint(data); n <= 0 {
+				return ErrProto
+			}
+			data = data[n:]
+		case wireFixed64:
+			if len(data) < 8 {
+				return ErrProto
+			}
+			v, data = binary.LittleEndian.Uint64(data), data[8:]
+		case wireBytes:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < size {
+				return ErrProto
+			}
+			b, data = data[n:n+int(size)], data[n+int(size):]
+		case wireFixed32:
+			if len(data) < 4 {
+				return ErrProto
+			}
+			v, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		default:
+			return ErrProto
+		}
+		if err := fn(num, wire, v, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snakeCase convert a Go field name to a protobuf field name,
+// e.g. CallerIDNum to caller_id_num
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var out strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			prev := runes[i-1]
+			next := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && next) {
+				out.WriteByte('_')
+			}
+		}
+		out.WriteRune(unicode.ToLower(r))
+	}
+	return out.String()
+}
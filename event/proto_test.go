@@ -0,0 +1,101 @@
+package event
+
+import (
+	"flag"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xytis/gami"
+)
+
+var update = flag.Bool("update", false, "update generated files")
+
+func TestProtoSchema(t *testing.T) {
+	schema := ProtoSchema()
+	if *update {
+		if err := os.WriteFile("event.proto", []byte(schema), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile("event.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != schema {
+		t.Fatal("event.proto is out of date, run go test -run TestProtoSchema -update")
+	}
+}
+
+func TestEnvelopeProto(t *testing.T) {
+	hangup := Hangup{
+		EventMeta: gami.EventMeta{
+			Received:  time.Unix(1400000000, 5).UTC(),
+			Sequence:  3,
+			Session:   "abc",
+			Timestamp: time.Unix(1400000000, 123000).UTC(),
+		},
+		Privilege: []string{"call", "all"},
+		Channel:   "SIP/100-00000001",
+		UniqueID:  "1400000000.1",
+		CauseText: "Normal Clearing",
+	}
+	env, err := NewEnvelope(hangup, "pbx1", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := env.MarshalProto()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded Envelope
+	if err := decoded.UnmarshalProto(data); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&decoded, env) {
+		t.Fatalf("Envelope proto round trip: %#v", decoded)
+	}
+
+	stats := RTPSenderStats{SSRC: "1", SendPackets: 7}
+	env, _ = NewEnvelope(stats, "", time.Time{})
+	data, _ = env.MarshalProto()
+	if err := decoded.UnmarshalProto(data); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.Payload, stats) {
+		t.Fatalf("Envelope proto int64 round trip: %#v", decoded.Payload)
+	}
+}
+
+func TestEnvelopeProtoRaw(t *testing.T) {
+	raw := gami.AMIEvent{ID: "NotTyped", Privilege: []string{"all"}, Params: gami.Params{"Foo": "Bar", "Baz": ""}}
+	env, _ := NewEnvelope(raw, "", time.Time{})
+	data, _ := env.MarshalProto()
+
+	var decoded Envelope
+	if err := decoded.UnmarshalProto(data); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.Payload, raw) {
+		t.Fatalf("Envelope proto raw round trip: %#v", decoded.Payload)
+	}
+
+	if err := decoded.UnmarshalProto([]byte{0x0a, 0x10}); err != ErrProto {
+		t.Fatal("Envelope proto malformed:", err)
+	}
+}
+
+func TestSnakeCase(t *testing.T) {
+	for name, expected := range map[string]string{
+		"CallerIDNum":  "caller_id_num",
+		"SSRC":         "ssrc",
+		"DestUniqueID": "dest_unique_id",
+		"CauseText":    "cause_text",
+	} {
+		if snakeCase(name) != expected {
+			t.Fatal("snakeCase", name, snakeCase(name))
+		}
+	}
+}
@@ -0,0 +1,127 @@
+// Package event for AMI
+package event
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// ErrDuplicateEvent raised when registering a name already taken by another type
+var ErrDuplicateEvent = errors.New("Event already registered")
+
+// ErrInvalidEvent raised when registering a type that can not be decoded
+var ErrInvalidEvent = errors.New("Invalid event type")
+
+// trapLock guards eventTrap and userEventTrap after init, and their indexes
+var trapLock sync.RWMutex
+
+// eventNames and userEventNames index the name a type is encoded with, the
+// first one registered when a type has several names
+var eventNames = make(map[reflect.Type]string)
+var userEventNames = make(map[reflect.Type]string)
+
+// Register add the type decoded by New for the event name. The type must be
+// a struct with the same layout as the built in events: an optional
+// Privilege []string, an optional embedded gami.EventMeta, and exported
+// string or int64 fields tagged with the AMI header they are read from.
+//
+// Registering the same type twice is a no-op, registering another type for
+// a name already taken fails with ErrDuplicateEvent, use Override to replace
+// a built in type. A type registered under several names is encoded with
+// the first one. Safe for concurrent use.
+func Register(name string, proto interface{}) error {
+	return register(eventTrap, eventNames, name, proto, false)
+}
+
+// Override register the type for the event name replacing any type
+// registered before, including the built in ones
+func Override(name string, proto interface{}) error {
+	return register(eventTrap, eventNames, name, proto, true)
+}
+
+func register(trap map[string]interface{}, names map[reflect.Type]string, name string, proto interface{}, override bool) error {
+	if name == "" {
+		return fmt.Errorf("%w: empty name", ErrInvalidEvent)
+	}
+	if err := validate(reflect.TypeOf(proto)); err != nil {
+		return err
+	}
+
+	trapLock.Lock()
+	defer trapLock.Unlock()
+	if klass, ok := trap[name]; ok && !override && reflect.TypeOf(klass) != reflect.TypeOf(proto) {
+		return fmt.Errorf("%w: %s is %T", ErrDuplicateEvent, name, klass)
+	}
+	// the names the type had before keep precedence
+	typ := reflect.TypeOf(proto)
+	if first, ok := firstName(trap, names, typ); ok && first != name {
+		names[typ] = first
+	} else {
+		names[typ] = name
+	}
+	trap[name] = proto
+	return nil
+}
+
+// firstName of the type in trap, from the index when it's still valid or
+// else the first sorted name, the caller holds trapLock
+func firstName(trap map[string]interface{}, names map[reflect.Type]string, typ reflect.Type) (string, bool) {
+	if name, ok := names[typ]; ok && reflect.TypeOf(trap[name]) == typ {
+		return name, true
+	}
+	// not indexed, e.g. the built in types registered by init
+	var found []string
+	for name, klass := range trap {
+		if reflect.TypeOf(klass) == typ {
+			found = append(found, name)
+		}
+	}
+	if len(found) == 0 {
+		return "", false
+	}
+	sort.Strings(found)
+	return found[0], true
+}
+
+// validate check build can decode the type
+func validate(typ reflect.Type) error {
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return ErrNotStruct
+	}
+	for ix := 0; ix < typ.NumField(); ix++ {
+		tfield := typ.Field(ix)
+		switch {
+		case tfield.Name == "Privilege":
+			if tfield.Type != reflect.TypeOf([]string(nil)) {
+				return fmt.Errorf("%w: %s.Privilege must be []string", ErrInvalidEvent, typ.Name())
+			}
+		case tfield.Type == metaType:
+		case tfield.PkgPath != "":
+			return fmt.Errorf("%w: %s.%s is not exported", ErrInvalidEvent, typ.Name(), tfield.Name)
+		case tfield.Tag.Get("AMI") == "":
+			return fmt.Errorf("%w: %s.%s has no AMI tag", ErrInvalidEvent, typ.Name(), tfield.Name)
+		case tfield.Type.Kind() != reflect.String && tfield.Type.Kind() != reflect.Int64:
+			return fmt.Errorf("%w: %s.%s must be string or int64", ErrInvalidEvent, typ.Name(), tfield.Name)
+		}
+	}
+	return nil
+}
+
+// lookup the type registered for the event name
+func lookup(name string) (interface{}, bool) {
+	trapLock.RLock()
+	defer trapLock.RUnlock()
+	klass, ok := eventTrap[name]
+	return klass, ok
+}
+
+// lookupUserEvent the type registered for the UserEvent name
+func lookupUserEvent(name string) (interface{}, bool) {
+	trapLock.RLock()
+	defer trapLock.RUnlock()
+	klass, ok := userEventTrap[name]
+	return klass, ok
+}
@@ -0,0 +1,142 @@
+package event
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/xytis/gami"
+)
+
+type dongleSMS struct {
+	gami.EventMeta
+	Privilege []string
+	Device    string `AMI:"Device"`
+	From      string `AMI:"From"`
+	Message   string `AMI:"Message"`
+}
+
+// restore the registration of name when the test ends
+func restore(t *testing.T, name string) {
+	klass, ok := lookup(name)
+	t.Cleanup(func() {
+		trapLock.Lock()
+		defer trapLock.Unlock()
+		if ok {
+			eventTrap[name] = klass
+		} else {
+			delete(eventTrap, name)
+		}
+	})
+}
+
+func TestRegister(t *testing.T) {
+	restore(t, "DongleNewSMS")
+	if err := Register("DongleNewSMS", dongleSMS{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Register("DongleNewSMS", dongleSMS{}); err != nil {
+		t.Fatal("Register the same type twice:", err)
+	}
+	if err := Register("DongleNewSMS", Hangup{}); !errors.Is(err, ErrDuplicateEvent) {
+		t.Fatal("Register another type:", err)
+	}
+
+	ev := gami.AMIEvent{ID: "DongleNewSMS", Params: gami.Params{"Device": "dongle0", "From": "+100", "Message": "hi"}}
+	sms, ok := New(&ev).(dongleSMS)
+	if !ok || sms.From != "+100" {
+		t.Fatal("Registered type not decoded:", New(&ev))
+	}
+}
+
+func TestRegisterBuiltin(t *testing.T) {
+	if err := Register("Hangup", dongleSMS{}); !errors.Is(err, ErrDuplicateEvent) {
+		t.Fatal("Register must not replace built in types:", err)
+	}
+
+	restore(t, "Hangup")
+	if err := Override("Hangup", dongleSMS{}); err != nil {
+		t.Fatal(err)
+	}
+	ev := gami.AMIEvent{ID: "Hangup", Params: gami.Params{"Device": "dongle0"}}
+	if _, ok := New(&ev).(dongleSMS); !ok {
+		t.Fatal("Override not applied:", New(&ev))
+	}
+}
+
+func TestRegisterValidation(t *testing.T) {
+	type privilege struct {
+		Privilege string
+	}
+	type untagged struct {
+		Channel string
+	}
+	type unexported struct {
+		channel string `AMI:"Channel"`
+	}
+	type float struct {
+		Duration float64 `AMI:"Duration"`
+	}
+
+	if err := Register("Bad", "string"); err != ErrNotStruct {
+		t.Fatal("Register must reject non struct:", err)
+	}
+	if err := Register("", dongleSMS{}); !errors.Is(err, ErrInvalidEvent) {
+		t.Fatal("Register must reject empty name:", err)
+	}
+	for _, proto := range []interface{}{privilege{}, untagged{}, unexported{}, float{}} {
+		if err := Register("Bad", proto); !errors.Is(err, ErrInvalidEvent) {
+			t.Fatalf("Register must reject %T: %v", proto, err)
+		}
+	}
+	if _, ok := lookup("Bad"); ok {
+		t.Fatal("Invalid type registered")
+	}
+}
+
+func TestRegisterConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		name := fmt.Sprint("Concurrent", i)
+		restore(t, name)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := Register(name, dongleSMS{}); err != nil {
+				t.Error(err)
+			}
+			New(&gami.AMIEvent{ID: name, Params: gami.Params{}})
+			Encode(dongleSMS{})
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRegisterAlias(t *testing.T) {
+	restore(t, "HangupRequest")
+	restore(t, "AHangup")
+	restore(t, "DongleSMS")
+	restore(t, "ADongleSMS")
+	for name, proto := range map[string]interface{}{"HangupRequest": Hangup{}, "AHangup": Hangup{}} {
+		if err := Register(name, proto); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Register("DongleSMS", dongleSMS{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Register("ADongleSMS", dongleSMS{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the name registered first is used to encode
+	for i := 0; i < 100; i++ {
+		if ev, err := Encode(Hangup{}); err != nil || ev.ID != "Hangup" {
+			t.Fatal("Hangup encoded as", ev.ID, err)
+		}
+		if ev, err := Encode(dongleSMS{}); err != nil || ev.ID != "DongleSMS" {
+			t.Fatal("dongleSMS encoded as", ev.ID, err)
+		}
+	}
+}
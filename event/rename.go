@@ -1,8 +1,11 @@
 // Package event for AMI
 package event
 
+import "github.com/xytis/gami"
+
 // Raised when the name of a channel is changed.
 type Rename struct {
+	gami.EventMeta
 	Privilege []string
 	Channel   string `AMI:"Channel"`
 	NewName   string `AMI:"Newname"`
@@ -1,8 +1,11 @@
 // Package event for AMI
 package event
 
+import "github.com/xytis/gami"
+
 // RTPReceiverStats triggered when exchanging rtp stats.
 type RTPReceiverStats struct {
+	gami.EventMeta
 	Privilege       []string
 	SSRC            string `AMI:"Ssrc"`
 	ReceivedPackets int64  `AMI:"Receivedpackets"`
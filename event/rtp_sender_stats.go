@@ -1,8 +1,11 @@
 // Package event for AMI
 package event
 
+import "github.com/xytis/gami"
+
 // RTPSenderStats triggered when exchanging rtp stats.
 type RTPSenderStats struct {
+	gami.EventMeta
 	Privilege   []string
 	SSRC        string `AMI:"Ssrc"`
 	SendPackets int64  `AMI:"Sendpackets"`
@@ -1,8 +1,11 @@
 // Package event for AMI
 package event
 
+import "github.com/xytis/gami"
+
 // Raised when Asterisk is shutdown or restarted.
 type Shutdown struct {
+	gami.EventMeta
 	Privilege []string
 	Shutdown  string `AMI:"Shutdown"`
 	Restart   string `AMI:"Restart"`
@@ -1,8 +1,21 @@
 // Package event for AMI
 package event
 
+import (
+	"errors"
+
+	"github.com/xytis/gami"
+)
+
+// ErrNotStruct raised when registering a type that is not a struct
+var ErrNotStruct = errors.New("Event type must be a struct")
+
+// ErrNotUserEvent raised when sending an event that is not a UserEvent
+var ErrNotUserEvent = errors.New("Not a UserEvent")
+
 // A user defined event raised from the dialplan.
 type UserEvent struct {
+	gami.EventMeta
 	Privilege []string
 	UserEvent string `AMI:"Userevent"`
 	UniqueID  string `AMI:"Uniqueid"`
@@ -11,3 +24,42 @@ type UserEvent struct {
 func init() {
 	eventTrap["UserEvent"] = UserEvent{}
 }
+
+// userEventTrap used internal for cast UserEvent by its name
+var userEventTrap = make(map[string]interface{})
+
+// RegisterUserEvent register a struct to decode the UserEvent with the given
+// name, New returns it instead of UserEvent. The struct follows the same rules
+// as Register, custom headers are read from the fields tagged AMI.
+//
+//	type CallScored struct {
+//		gami.EventMeta
+//		Privilege []string
+//		CallID    string `AMI:"Callid"`
+//		Score     int64  `AMI:"Score"`
+//	}
+//
+//	event.RegisterUserEvent("CallScored", CallScored{})
+func RegisterUserEvent(name string, proto interface{}) error {
+	return register(userEventTrap, userEventNames, name, proto, false)
+}
+
+// SendUserEvent emit a UserEvent through the client, the event can be a
+// registered user event struct or a UserEvent
+func SendUserEvent(client *gami.AMIClient, event interface{}) (*gami.AMIResponse, error) {
+	ev, err := Encode(event)
+	if err != nil {
+		return nil, err
+	}
+	if ev.ID != "UserEvent" {
+		return nil, ErrNotUserEvent
+	}
+	params := gami.Params{"UserEvent": ev.Params["Userevent"]}
+	for k, v := range ev.Params {
+		if k == "Userevent" || k == "Uniqueid" {
+			continue
+		}
+		params[k] = v
+	}
+	return client.Action("UserEvent", params)
+}
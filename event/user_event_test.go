@@ -1,9 +1,13 @@
 package event
 
 import (
+	"encoding/json"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/xytis/gami"
+	"github.com/xytis/gami/gamitest"
 )
 
 func TestUserEventEvent(t *testing.T) {
@@ -26,3 +30,108 @@ func TestUserEventEvent(t *testing.T) {
 
 	testEvent(t, fixture, evtype)
 }
+
+type callScored struct {
+	gami.EventMeta
+	Privilege []string
+	CallID    string `AMI:"CallID"`
+	Score     int64  `AMI:"Score"`
+}
+
+func TestRegisterUserEvent(t *testing.T) {
+	if err := RegisterUserEvent("CallScored", callScored{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterUserEvent("Bad", "string"); err != ErrNotStruct {
+		t.Fatal("RegisterUserEvent must reject non struct:", err)
+	}
+
+	ev := gami.AMIEvent{
+		ID:        "UserEvent",
+		Privilege: []string{"user", "all"},
+		Params:    gami.Params{"Userevent": "CallScored", "Callid": "42", "Score": "7"},
+	}
+	scored, ok := New(&ev).(callScored)
+	if !ok {
+		t.Fatal("callScored type assertion")
+	}
+	if scored.CallID != "42" || scored.Score != 7 {
+		t.Fatal("callScored fields:", scored)
+	}
+
+	encoded, err := Encode(scored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encoded.ID != "UserEvent" || encoded.Params["Userevent"] != "CallScored" || encoded.Params["CallID"] != "42" {
+		t.Fatal("Encode user event:", encoded)
+	}
+
+	ev.Params["Userevent"] = "Other"
+	if _, ok := New(&ev).(UserEvent); !ok {
+		t.Fatal("Unregistered user events must decode as UserEvent")
+	}
+}
+
+func TestUserEventEnvelope(t *testing.T) {
+	if err := RegisterUserEvent("CallScored", callScored{}); err != nil {
+		t.Fatal(err)
+	}
+	scored := callScored{Privilege: []string{"user"}, CallID: "42", Score: 7}
+	env, err := NewEnvelope(scored, "", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Envelope
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.Payload, scored) {
+		t.Fatalf("user event JSON round trip: %#v", decoded.Payload)
+	}
+
+	data, err = env.MarshalProto()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := decoded.UnmarshalProto(data); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.Payload, scored) {
+		t.Fatalf("user event proto round trip: %#v", decoded.Payload)
+	}
+}
+
+func TestSendUserEvent(t *testing.T) {
+	if err := RegisterUserEvent("CallScored", callScored{}); err != nil {
+		t.Fatal(err)
+	}
+	srv := gamitest.NewServer()
+	defer srv.Close()
+	srv.Respond("UserEvent", gamitest.Response("Success"))
+
+	ami, err := gami.Connect(srv.Addr, "admin", "any")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SendUserEvent(ami, callScored{CallID: "42", Score: 7}); err != nil {
+		t.Fatal(err)
+	}
+
+	action, ok := srv.WaitAction("UserEvent", time.Second)
+	if !ok {
+		t.Fatal("UserEvent action not sent")
+	}
+	if action.Headers.Get("UserEvent") != "CallScored" || action.Headers.Get("CallID") != "42" || action.Headers.Get("Score") != "7" {
+		t.Fatal("UserEvent action headers:", action.Headers)
+	}
+
+	if _, err := SendUserEvent(ami, Hangup{}); err != ErrNotUserEvent {
+		t.Fatal("SendUserEvent must reject other events:", err)
+	}
+}
@@ -1,8 +1,11 @@
 // Package event for AMI
 package event
 
+import "github.com/xytis/gami"
+
 // VarSet triggered when a variable is set via agi or dialplan.
 type VarSet struct {
+	gami.EventMeta
 	Privilege    []string
 	Channel      string `AMI:"Channel"`
 	VariableName string `AMI:"Variable"`
@@ -0,0 +1,308 @@
+package gami
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Raise when a Failover can't connect to any of its servers
+var ErrNoServer = errors.New("No server available")
+
+// Switch tells a Failover moved to another server, events may have been
+// missed and state built from them should be reloaded
+type Switch struct {
+	Time time.Time
+	// From and To are the addresses of the servers
+	From string
+	To   string
+	// Err is the reason the previous connection was lost
+	Err error
+}
+
+// Failover is a client for an active/standby pair, or more servers, that
+// connects to the first one ready and moves to another when the connection
+// is lost. A server is ready once it sent FullyBooted, so the user needs
+// the system read class. Actions given to Subscribe, e.g. Events or Filter,
+// are sent again on every connection.
+type Failover struct {
+	// Events of the current server
+	Events chan *AMIEvent
+	// Errors of the current server
+	Errors chan error
+	// Switches receive a Switch every time the server changes, it must be
+	// read like Events
+	Switches chan Switch
+
+	// ReadyTimeout is how long a server has to send FullyBooted
+	ReadyTimeout time.Duration
+	// RetryInterval between two rounds over the servers
+	RetryInterval time.Duration
+
+	addresses []string
+	user      string
+	secret    string
+	options   []Option
+
+	mu            sync.Mutex
+	client        *AMIClient
+	address       string
+	subscriptions []subscription
+
+	wg   sync.WaitGroup
+	done chan struct{}
+	once sync.Once
+}
+
+type subscription struct {
+	action  string
+	headers Headers
+}
+
+// NewFailover create a failover client for the servers, in order of
+// preference. Connect starts it.
+func NewFailover(addresses []string, user string, secret string, options ...Option) *Failover {
+	return &Failover{
+		Events:        make(chan *AMIEvent),
+		Errors:        make(chan error),
+		Switches:      make(chan Switch),
+		ReadyTimeout:  10 * time.Second,
+		RetryInterval: time.Second,
+		addresses:     addresses,
+		user:          user,
+		secret:        secret,
+		options:       options,
+		done:          make(chan struct{}),
+	}
+}
+
+// Connect to the first server ready, then keep a server connected until
+// Shutdown
+func (fo *Failover) Connect() error {
+	client, ix, sent, err := fo.connectAny(0)
+	if err != nil {
+		return err
+	}
+	fo.set(client, fo.addresses[ix], sent)
+	fo.wg.Add(1)
+	go fo.run(client, ix)
+	return nil
+}
+
+// Subscribe send an action on the current connection and on every new one,
+// e.g. Events with an EventMask or Filter
+func (fo *Failover) Subscribe(action string, params ActionParams) error {
+	var headers Headers
+	if params != nil {
+		headers = append(headers, params.Headers()...)
+		headers.Del("ActionID")
+	}
+	fo.mu.Lock()
+	fo.subscriptions = append(fo.subscriptions, subscription{action, headers})
+	client := fo.client
+	fo.mu.Unlock()
+	if client == nil {
+		return nil
+	}
+	return subscribe(client, subscription{action, headers})
+}
+
+// Client return the client of the current server
+func (fo *Failover) Client() *AMIClient {
+	fo.mu.Lock()
+	defer fo.mu.Unlock()
+	return fo.client
+}
+
+// Address return the address of the current server
+func (fo *Failover) Address() string {
+	fo.mu.Lock()
+	defer fo.mu.Unlock()
+	return fo.address
+}
+
+// Action send an action to the current server
+func (fo *Failover) Action(action string, params ActionParams) (*AMIResponse, error) {
+	client := fo.Client()
+	if client == nil {
+		return nil, ErrNoServer
+	}
+	return client.Action(action, params)
+}
+
+// AsyncAction send an action to the current server
+func (fo *Failover) AsyncAction(action string, params ActionParams) (<-chan *AMIResponse, error) {
+	client := fo.Client()
+	if client == nil {
+		return nil, ErrNoServer
+	}
+	return client.AsyncAction(action, params)
+}
+
+// Shutdown the current client and stop moving to other servers, then close
+// Events, Errors and Switches
+func (fo *Failover) Shutdown(ctx context.Context) error {
+	var err error
+	fo.once.Do(func() {
+		close(fo.done)
+		if client := fo.Client(); client != nil {
+			err = client.Shutdown(ctx)
+		}
+		fo.wg.Wait()
+		close(fo.Events)
+		close(fo.Errors)
+		close(fo.Switches)
+	})
+	return err
+}
+
+// set the current client, subscribed with the first sent subscriptions.
+// The subscriptions added while it was connecting are sent to it.
+func (fo *Failover) set(client *AMIClient, address string, sent int) {
+	fo.mu.Lock()
+	fo.client, fo.address = client, address
+	missed := append([]subscription(nil), fo.subscriptions[sent:]...)
+	fo.mu.Unlock()
+	for _, s := range missed {
+		if err := subscribe(client, s); err != nil {
+			client.log().Warn("subscription failed", "action", s.action, "error", err)
+		}
+	}
+}
+
+// run forward the current client and move to another server when it's lost
+func (fo *Failover) run(client *AMIClient, ix int) {
+	defer fo.wg.Done()
+	for {
+		lost := fo.forward(client)
+		if lost == nil {
+			return
+		}
+		client.log().Warn("failing over", "error", lost)
+
+		// the other servers first, the lost one last
+		var next *AMIClient
+		var nix, sent int
+		for {
+			var err error
+			if next, nix, sent, err = fo.connectAny(ix + 1); err == nil {
+				break
+			}
+			select {
+			case <-fo.done:
+				return
+			case <-time.After(fo.RetryInterval):
+			}
+		}
+
+		select {
+		case <-fo.done:
+			next.Shutdown(context.Background())
+			return
+		default:
+		}
+		fo.set(next, fo.addresses[nix], sent)
+		next.measure().Reconnected()
+		next.log().Info("failed over", "from", fo.addresses[ix], "error", lost)
+		select {
+		case fo.Switches <- Switch{Time: time.Now(), From: fo.addresses[ix], To: fo.addresses[nix], Err: lost}:
+		case <-fo.done:
+			return
+		}
+		client, ix = next, nix
+	}
+}
+
+// forward the events and errors of client, returning why it was lost or
+// nil on Shutdown
+func (fo *Failover) forward(client *AMIClient) error {
+	events, errs := client.Events, client.Errors
+	for events != nil || errs != nil {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			select {
+			case fo.Events <- ev:
+			case <-fo.done:
+				return nil
+			}
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			select {
+			case fo.Errors <- err:
+			case <-fo.done:
+				return nil
+			}
+		case <-fo.done:
+			return nil
+		}
+	}
+	select {
+	case err := <-client.Fatal:
+		return err
+	case <-fo.done:
+		return nil
+	}
+}
+
+// connectAny try every server once starting at index from, returning the
+// first client ready and subscribed, with the number of subscriptions sent
+func (fo *Failover) connectAny(from int) (*AMIClient, int, int, error) {
+	errs := []error{ErrNoServer}
+	for n := range fo.addresses {
+		ix := (from + n) % len(fo.addresses)
+		client, sent, err := fo.connectOne(fo.addresses[ix])
+		if err == nil {
+			return client, ix, sent, nil
+		}
+		errs = append(errs, &ServerError{fo.addresses[ix], err})
+	}
+	return nil, 0, 0, errors.Join(errs...)
+}
+
+func (fo *Failover) connectOne(address string) (*AMIClient, int, error) {
+	client, err := Connect(address, fo.user, fo.secret, fo.options...)
+	if err != nil {
+		if client != nil {
+			client.Shutdown(context.Background())
+		}
+		return nil, 0, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), fo.ReadyTimeout)
+	defer cancel()
+	var subscriptions []subscription
+	if err = client.WaitReady(ctx); err == nil {
+		fo.mu.Lock()
+		subscriptions = append(subscriptions, fo.subscriptions...)
+		fo.mu.Unlock()
+		for _, s := range subscriptions {
+			if err = subscribe(client, s); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		client.Shutdown(context.Background())
+		return nil, 0, err
+	}
+	return client, len(subscriptions), nil
+}
+
+// subscribe send a subscription, an Error response fails it
+func subscribe(client *AMIClient, s subscription) error {
+	response, err := client.Action(s.action, append(Headers(nil), s.headers...))
+	if err != nil {
+		return err
+	}
+	if response.Status == "Error" {
+		return errors.New(response.Params["Message"])
+	}
+	return nil
+}
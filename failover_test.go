@@ -0,0 +1,108 @@
+package gami_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xytis/gami"
+	"github.com/xytis/gami/gamitest"
+)
+
+func TestFailover(t *testing.T) {
+	primary, backup := gamitest.NewServer(), gamitest.NewServer()
+	defer backup.Close()
+	primary.Boot()
+	backup.Boot()
+
+	fo := gami.NewFailover([]string{primary.Addr, backup.Addr}, "admin", "secret")
+	fo.RetryInterval = 10 * time.Millisecond
+	assert.NoError(t, fo.Connect())
+	assert.Equal(t, primary.Addr, fo.Address())
+	assert.Equal(t, "FullyBooted", (<-fo.Events).ID)
+
+	assert.NoError(t, fo.Subscribe("Events", gami.Params{"EventMask": "call"}))
+	assert.NoError(t, fo.Subscribe("Filter", gami.Params{"Operation": "Add", "Filter": "!Channel: Local/"}))
+	primary.Emit(gamitest.Event("Newchannel", "Privilege", "call,all", "Channel", "SIP/100-00000001"))
+	assert.Equal(t, "SIP/100-00000001", (<-fo.Events).Params["Channel"])
+
+	primary.Close()
+	sw := <-fo.Switches
+	assert.Equal(t, primary.Addr, sw.From)
+	assert.Equal(t, backup.Addr, sw.To)
+	assert.Error(t, sw.Err)
+	assert.Equal(t, backup.Addr, fo.Address())
+
+	// the subscriptions were sent again before the switch
+	mask, ok := backup.WaitAction("Events", time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, "call", mask.Headers.Get("EventMask"))
+	filter, ok := backup.WaitAction("Filter", time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, "!Channel: Local/", filter.Headers.Get("Filter"))
+
+	assert.Equal(t, "FullyBooted", (<-fo.Events).ID)
+	backup.Emit(gamitest.Event("Newchannel", "Privilege", "call,all", "Channel", "Local/200@default-00000001;1"))
+	backup.Emit(gamitest.Event("Newchannel", "Privilege", "call,all", "Channel", "SIP/200-00000002"))
+	assert.Equal(t, "SIP/200-00000002", (<-fo.Events).Params["Channel"])
+
+	response, err := fo.Action("Ping", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "Success", response.Status)
+
+	assert.NoError(t, fo.Shutdown(context.Background()))
+	_, ok = <-fo.Events
+	assert.False(t, ok)
+	_, ok = <-fo.Switches
+	assert.False(t, ok)
+}
+
+func TestFailoverSubscribeWhileConnecting(t *testing.T) {
+	srv := gamitest.NewServer()
+	defer srv.Close()
+	srv.Boot()
+	srv.Respond("Events", gamitest.Response("Success", "Events", "On"))
+	srv.Respond("Filter", gamitest.Response("Success"))
+	srv.Delay("Events", 50*time.Millisecond)
+
+	fo := gami.NewFailover([]string{srv.Addr}, "admin", "secret")
+	defer fo.Shutdown(context.Background())
+	assert.NoError(t, fo.Subscribe("Events", gami.Params{"EventMask": "call"}))
+	connected := make(chan error, 1)
+	go func() {
+		connected <- fo.Connect()
+	}()
+
+	// subscribed while the client sends the previous subscriptions
+	_, ok := srv.WaitAction("Events", time.Second)
+	assert.True(t, ok)
+	assert.NoError(t, fo.Subscribe("Filter", gami.Params{"Operation": "Add", "Filter": "!Channel: Local/"}))
+	assert.NoError(t, <-connected)
+	_, ok = srv.WaitAction("Filter", time.Second)
+	assert.True(t, ok, "subscription not sent to the new client")
+}
+
+func TestFailoverNotReady(t *testing.T) {
+	// the primary is up but not booted, e.g. a standby
+	primary, backup := gamitest.NewServer(), gamitest.NewServer()
+	defer primary.Close()
+	defer backup.Close()
+	backup.Boot()
+
+	fo := gami.NewFailover([]string{primary.Addr, backup.Addr}, "admin", "secret")
+	fo.ReadyTimeout = 20 * time.Millisecond
+	assert.NoError(t, fo.Connect())
+	assert.Equal(t, backup.Addr, fo.Address())
+	assert.NoError(t, fo.Shutdown(context.Background()))
+
+	backup.Close()
+	fo = gami.NewFailover([]string{primary.Addr, backup.Addr}, "admin", "secret")
+	fo.ReadyTimeout = 20 * time.Millisecond
+	err := fo.Connect()
+	assert.True(t, errors.Is(err, gami.ErrNoServer))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	_, err = fo.Action("Ping", nil)
+	assert.Equal(t, gami.ErrNoServer, err)
+}
@@ -1,18 +1,31 @@
 package gami
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
-	"io"
+	"log/slog"
 	"net"
 	"net/textproto"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Raise when not response expected protocol AMI
 var ErrNotAMI = errors.New("Server not AMI interface")
 
+// Raise when using a client after the connection was closed
+var ErrClosed = errors.New("Connection closed")
+
+// discard is the logger of clients created without WithLogger
+var discard = slog.New(slog.DiscardHandler)
+
 // Params for the actions
 type Params map[string]string
 
@@ -31,18 +44,63 @@ type AMIClient struct {
 	amiUser string
 	amiPass string
 
+	mu       sync.Mutex
 	opNumber int
 	opPrefix string
 
+	session  string
+	sequence uint64
+	version  string
+
+	dial    func(network, address string) (net.Conn, error)
+	logger  *slog.Logger
+	metrics Metrics
+
+	actionInterceptors []ActionInterceptor
+	eventInterceptors  []EventInterceptor
+	principals         []*Principal
+	scheduler          *scheduler
+
+	recorder     *Recorder
+	tracer       func(Trace)
+	writeTimeout time.Duration
+	writeLock    sync.Mutex
+	batchSize    int
+	writes       chan writeRequest
+	pingInterval time.Duration
+	pingTimeout  time.Duration
+	idleTimeout  time.Duration
+	lastRead     atomic.Int64
+	dead         error
+
+	stateMu       sync.Mutex
+	state         State
+	booted        bool
+	ready         chan struct{}
+	stateObserver func(Transition)
+
 	raw      chan textproto.MIMEHeader
 	closing  chan chan error
+	done     chan struct{}
+	stopping chan struct{}
+	stopOnce sync.Once
+	drained  chan struct{}
 	response map[string]chan *AMIResponse
+	inflight map[string]inflight
 
 	Events chan *AMIEvent
 	Errors chan error
 	Fatal  chan error
 }
 
+// inflight is an action waiting for its response
+type inflight struct {
+	action string
+	sent   time.Time
+	// scheduled holds a scheduler slot, released with the response
+	scheduled bool
+}
+
 // AMIResponse from action
 type AMIResponse struct {
 	ID     string
@@ -61,64 +119,269 @@ type AMIEvent struct {
 	ID        string
 	Privilege []string
 	Params    Params
+	EventMeta
+}
+
+// EventMeta tells when and where an event was received
+type EventMeta struct {
+	// Received is the local time the event was read, with a monotonic clock reading
+	Received time.Time
+	// Sequence of the event on its connection, starting at 1
+	Sequence uint64
+	// Session identifies the connection the event was read from
+	Session string
+	// Timestamp is the Asterisk Timestamp header, zero unless timestampevents=yes
+	Timestamp time.Time
+	// Server is the name of the server the event came from in a Pool
+	Server string
+}
+
+// MarshalAMI encode the event in the wire format used by AMI, params are
+// written sorted by key so the output is deterministic
+func (ev *AMIEvent) MarshalAMI() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("Event: " + ev.ID + "\r\n")
+	if privilege := strings.Join(ev.Privilege, ","); privilege != "" {
+		buf.WriteString("Privilege: " + privilege + "\r\n")
+	}
+	keys := make([]string, 0, len(ev.Params))
+	for k := range ev.Params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		buf.WriteString(k + ": " + ev.Params[k] + "\r\n")
+	}
+	buf.WriteString("\r\n")
+	return buf.Bytes()
 }
 
 // AsyncAction returns chan for wait response of action with parameter *ActionID* this can be helpful for
 // massive actions,
-func (client *AMIClient) AsyncAction(action string, params Params) (<-chan *AMIResponse, error) {
-	if params == nil {
-		params = Params{}
+// it blocks while the action is held back by WithRateLimit or WithMaxInFlight
+func (client *AMIClient) AsyncAction(action string, params ActionParams) (<-chan *AMIResponse, error) {
+	_, resp, err := client.asyncAction(action, params)
+	return resp, err
+}
+
+func (client *AMIClient) asyncAction(action string, params ActionParams) (string, <-chan *AMIResponse, error) {
+	// the action is written trimmed, the interceptors see the same name
+	action = strings.TrimSpace(action)
+	var headers Headers
+	if params != nil {
+		headers = append(headers, params.Headers()...)
+	}
+	if err := client.check(action, headers); err != nil {
+		return "", nil, err
 	}
 
-	if _, ok := params["ActionID"]; !ok {
-		params["ActionID"] = client.opPrefix + strconv.Itoa(client.opNumber)
+	client.mu.Lock()
+	actionID := headers.Get("ActionID")
+	if actionID == "" {
+		actionID = client.opPrefix + strconv.Itoa(client.opNumber)
 		client.opNumber += 1
+		headers.Add("ActionID", actionID)
+		if p, ok := params.(Params); ok && p != nil {
+			p["ActionID"] = actionID
+		}
 	}
+	client.mu.Unlock()
 
-	if err := client.conn.PrintfLine("Action: %s", strings.TrimSpace(action)); err != nil {
-		return nil, err
-	}
+	req := &ActionRequest{Action: action, Headers: headers}
+	resp, err := chainActions(client.actionInterceptors, client.send)(req)
+	return req.Headers.Get("ActionID"), resp, err
+}
 
-	if _, ok := client.response[params["ActionID"]]; !ok {
-		client.response[params["ActionID"]] = make(chan *AMIResponse)
+// check the action and its headers can be written safely
+func (client *AMIClient) check(action string, headers Headers) error {
+	if err := checkHeader("Action", action); err != nil {
+		client.measure().ActionFailed(action)
+		return err
 	}
+	for _, header := range headers {
+		if err := checkHeader(header.Key, header.Value); err != nil {
+			client.measure().ActionFailed(action)
+			return err
+		}
+	}
+	return nil
+}
 
-	for k, v := range params {
-		if err := client.conn.PrintfLine("%s: %s", k, strings.TrimSpace(v)); err != nil {
+// send is the ActionHandler at the end of the interceptor chain, it writes
+// the action and registers its response
+func (client *AMIClient) send(req *ActionRequest) (<-chan *AMIResponse, error) {
+	action, headers := strings.TrimSpace(req.Action), req.Headers
+	if len(client.actionInterceptors) > 0 {
+		// interceptors may have changed the request
+		if err := client.check(action, headers); err != nil {
 			return nil, err
 		}
+		for _, principal := range client.principals {
+			if err := principal.CanWrite(action); err != nil {
+				return nil, err
+			}
+		}
 	}
+	if client.scheduler != nil {
+		if err := client.scheduler.acquire(action, client.done); err != nil {
+			if err == ErrActionBlocked {
+				client.measure().ActionFailed(action)
+			}
+			return nil, err
+		}
+	}
+	return client.transmit(action, headers, client.scheduler != nil)
+}
 
-	if err := client.conn.PrintfLine(""); err != nil {
+// transmit register the response channel of an action and write it,
+// scheduled tells the action holds a scheduler slot
+func (client *AMIClient) transmit(action string, headers Headers, scheduled bool) (<-chan *AMIResponse, error) {
+	actionID := headers.Get("ActionID")
+	client.mu.Lock()
+	select {
+	case <-client.done:
+		client.mu.Unlock()
+		if scheduled {
+			client.release()
+		}
+		return nil, ErrClosed
+	default:
+	}
+	resp, ok := client.response[actionID]
+	if !ok {
+		resp = make(chan *AMIResponse, 1)
+		client.response[actionID] = resp
+	}
+	if client.inflight == nil {
+		client.inflight = make(map[string]inflight)
+	}
+	if previous, ok := client.inflight[actionID]; ok && previous.scheduled {
+		// ActionID reused, the response will answer the new action
+		client.release()
+	}
+	client.inflight[actionID] = inflight{action, time.Now(), scheduled}
+	client.mu.Unlock()
+
+	frame := buildFrame(action, headers)
+	if err := client.write(frame); err != nil {
+		client.forget(actionID)
+		client.measure().ActionFailed(action)
+		client.log().Error("action write failed", "action", action, "action_id", actionID, "error", err)
 		return nil, err
 	}
 
-	return client.response[params["ActionID"]], nil
+	client.measure().ActionSent(action)
+	client.measure().BytesOut(len(frame))
+	client.log().Debug("action sent", "action", action, "action_id", actionID)
+	return resp, nil
+}
+
+// forget an action, its response channel is closed and a late response is
+// dropped
+func (client *AMIClient) forget(actionID string) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if resp, ok := client.response[actionID]; ok {
+		close(resp)
+	}
+	if start, ok := client.inflight[actionID]; ok && start.scheduled {
+		client.release()
+	}
+	delete(client.response, actionID)
+	delete(client.inflight, actionID)
+	client.settle()
+}
+
+// settle signal Shutdown once no action waits for a response, called with
+// mu held
+func (client *AMIClient) settle() {
+	if client.drained != nil && len(client.response) == 0 {
+		close(client.drained)
+		client.drained = nil
+	}
+}
+
+// fail the actions waiting for a response, called by main once done is
+// closed
+func (client *AMIClient) fail() {
+	client.mu.Lock()
+	for actionID, resp := range client.response {
+		close(resp)
+		if start, ok := client.inflight[actionID]; ok && start.scheduled {
+			client.release()
+		}
+	}
+	client.response = make(map[string]chan *AMIResponse)
+	client.inflight = nil
+	client.settle()
+	client.mu.Unlock()
+}
+
+// stop close Events and Errors, called by main when it returns
+func (client *AMIClient) stop(reason string) {
+	client.setState(StateClosed, reason)
+	close(client.Events)
+	close(client.Errors)
+}
+
+// release the scheduler slot of an action
+func (client *AMIClient) release() {
+	if client.scheduler != nil {
+		client.scheduler.release()
+	}
 }
 
 // Action send with params
-func (client *AMIClient) Action(action string, params Params) (*AMIResponse, error) {
-	resp, err := client.AsyncAction(action, params)
+func (client *AMIClient) Action(action string, params ActionParams) (*AMIResponse, error) {
+	actionID, resp, err := client.asyncAction(action, params)
 	if err != nil {
 		return nil, err
 	}
 	select {
-	case response := <-resp:
+	case response, ok := <-resp:
+		if !ok {
+			return nil, ErrClosed
+		}
 		return response, nil
 	case <-time.After(time.Second * 5):
+		client.forget(actionID)
+		client.measure().ActionTimedOut(action)
+		client.log().Warn("action timed out", "action", action, "action_id", actionID)
 		return nil, errors.New("operation timed out")
 	}
 }
 
+// poll read the connection until it fails, closing raw to stop main
 func (client *AMIClient) poll() {
+	defer close(client.raw)
 	for {
 		if data, err := client.conn.ReadMIMEHeader(); err != nil {
+			select {
+			case <-client.stopping:
+				// closed by Shutdown
+				return
+			default:
+			}
+			client.mu.Lock()
+			if client.dead != nil {
+				err = client.dead
+			}
+			client.mu.Unlock()
 			//When underlying connection is closed, reader 'sometimes' returns EOF
-			client.Fatal <- err
-			close(client.closing)
+			client.log().Error("connection lost", "error", err)
+			select {
+			case client.Fatal <- err:
+			case <-client.done:
+			}
 			return
 		} else {
-			client.raw <- data
+			client.lastRead.Store(time.Now().UnixNano())
+			client.measure().BytesIn(frameSize(data))
+			select {
+			case client.raw <- data:
+			case <-client.done:
+				return
+			}
 		}
 	}
 }
@@ -126,11 +389,24 @@ func (client *AMIClient) poll() {
 func (client *AMIClient) main() {
 	var pendingEvent []*AMIEvent
 	var pendingError []error
+	deliver := chainEvents(client.eventInterceptors, func(event *AMIEvent) {
+		pendingEvent = append(pendingEvent, event)
+	})
+	raw := client.raw
 	for {
 		var currentEvent *AMIEvent
 		var currentError error
 		var events chan *AMIEvent
 		var errors chan error
+		var stopping chan struct{}
+		if raw == nil {
+			// connection lost, deliver what was read then stop
+			if len(pendingEvent) == 0 && len(pendingError) == 0 {
+				client.stop("connection lost")
+				return
+			}
+			stopping = client.stopping
+		}
 		if len(pendingEvent) > 0 {
 			currentEvent = pendingEvent[0]
 			events = client.Events
@@ -140,33 +416,71 @@ func (client *AMIClient) main() {
 			errors = client.Errors
 		}
 		select {
-		case errc, ok := <-client.closing:
-			if ok {
-				err := client.conn.Close()
-				errc <- err
-			}
+		case errc := <-client.closing:
 			//Closing to notify that we are offline
-			close(client.Events)
+			if client.done != nil {
+				close(client.done)
+			}
+			errc <- client.conn.Close()
+			client.fail()
+			client.stop("closed")
+			return
+		case <-stopping:
+			client.stop("closed")
 			return
-		case data, ok := <-client.raw:
+		case data, ok := <-raw:
 			if !ok {
-				// client.raw got closed?
+				// connection lost
+				raw = nil
+				if client.done != nil {
+					close(client.done)
+				}
+				client.conn.Close()
+				client.fail()
 				continue
 			}
 			if data.Get("Response") != "" {
 				if response, err := newResponse(&data); err == nil {
-					//TODO: will block whole server on bad consume
-					client.response[response.ID] <- response
-					close(client.response[response.ID])
+					client.mu.Lock()
+					resp, ok := client.response[response.ID]
+					start, sent := client.inflight[response.ID]
 					delete(client.response, response.ID)
+					delete(client.inflight, response.ID)
+					if ok {
+						// buffered, never blocks
+						resp <- response
+						close(resp)
+					}
+					client.settle()
+					client.mu.Unlock()
+					if sent {
+						if start.scheduled {
+							client.release()
+						}
+						client.measure().ActionLatency(start.action, time.Since(start.sent))
+						if response.Status == "Error" {
+							client.measure().ActionFailed(start.action)
+						}
+					}
+					if !ok {
+						client.log().Warn("response dropped, no action waiting", "action_id", response.ID)
+					}
 				} else {
+					client.log().Warn("response decode failed", "error", err)
 					pendingError = append(pendingError, err)
 				}
 			}
 			if data.Get("Event") != "" {
 				if event, err := newEvent(&data); err == nil {
-					pendingEvent = append(pendingEvent, event)
+					client.sequence++
+					event.Received = time.Now()
+					event.Sequence = client.sequence
+					event.Session = client.session
+					client.measure().EventReceived(event.ID)
+					client.watch(event)
+					deliver(event)
 				} else {
+					client.log().Warn("event decode failed", "event", data.Get("Event"), "error", err)
 					pendingError = append(pendingError, err)
 				}
 			}
@@ -175,6 +489,7 @@ func (client *AMIClient) main() {
 		case errors <- currentError:
 			pendingError = pendingError[1:]
 		}
+		client.measure().QueueDepth(len(pendingEvent), len(pendingError))
 	}
 }
 
@@ -182,9 +497,16 @@ func (client *AMIClient) main() {
 func (client *AMIClient) run() {
 	go client.main()
 	go client.poll()
+	if client.batchSize > 0 {
+		go client.writer()
+	}
+	if client.pingInterval > 0 || client.idleTimeout > 0 {
+		client.lastRead.Store(time.Now().UnixNano())
+		go client.keepalive()
+	}
 }
 
-//newResponse build a response for action
+// newResponse build a response for action
 func newResponse(data *textproto.MIMEHeader) (*AMIResponse, error) {
 	if data.Get("Response") == "" {
 		return nil, errors.New("Not Response")
@@ -209,12 +531,15 @@ func newResponse(data *textproto.MIMEHeader) (*AMIResponse, error) {
 	return response, nil
 }
 
-//newEvent build event
+// newEvent build event
 func newEvent(data *textproto.MIMEHeader) (*AMIEvent, error) {
 	if data.Get("Event") == "" {
 		return nil, errors.New("Not Event")
 	}
-	ev := &AMIEvent{data.Get("Event"), strings.Split(data.Get("Privilege"), ","), make(map[string]string)}
+	ev := &AMIEvent{ID: data.Get("Event"), Privilege: strings.Split(data.Get("Privilege"), ","), Params: make(map[string]string)}
+	if timestamp := data.Get("Timestamp"); timestamp != "" {
+		ev.Timestamp, _ = parseTimestamp(timestamp)
+	}
 	for k, v := range *data {
 		if k == "Event" || k == "Privilege" {
 			continue
@@ -224,78 +549,234 @@ func newEvent(data *textproto.MIMEHeader) (*AMIEvent, error) {
 	return ev, nil
 }
 
+// parseTimestamp parse the Timestamp header, seconds since epoch with
+// optional microseconds e.g. 1400000000.123456
+func parseTimestamp(value string) (time.Time, error) {
+	sec, frac := value, ""
+	if ix := strings.IndexByte(value, '.'); ix >= 0 {
+		sec, frac = value[:ix], value[ix+1:]
+	}
+	s, err := strconv.ParseInt(sec, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var ns int64
+	if frac != "" {
+		if len(frac) > 9 {
+			frac = frac[:9]
+		}
+		if ns, err = strconv.ParseInt(frac+strings.Repeat("0", 9-len(frac)), 10, 64); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return time.Unix(s, ns), nil
+}
+
+// newSession generate an identifier for a connection
+func newSession() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// Session identifies the current connection, it is copied on every event
+func (client *AMIClient) Session() string {
+	return client.session
+}
+
 // Create a new connection to AMI
-func Connect(address string, user string, secret string) (client *AMIClient, err error) {
-	client = &AMIClient{
+func Connect(address string, user string, secret string, options ...Option) (client *AMIClient, err error) {
+	client = newClient(address, options)
+	rwc, err := client.dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if client.pingInterval > 0 {
+		setTCPKeepAlive(rwc, client.pingInterval)
+	}
+	if err = client.bind(NewStreamTransport(rwc)); err != nil {
+		rwc.Close()
+		return nil, err
+	}
+	client.run()
+	if user != "" {
+		return client, client.login(user, secret)
+	}
+	return client, nil
+}
+
+// NewClient start a client over an established transport, e.g. a
+// connection accepted from Asterisk or tunneled through some other
+// channel wrapped by NewStreamTransport
+func NewClient(transport Transport, user string, secret string, options ...Option) (client *AMIClient, err error) {
+	client = newClient("", options)
+	if err = client.bind(transport); err != nil {
+		return nil, err
+	}
+	client.run()
+	if user != "" {
+		return client, client.login(user, secret)
+	}
+	return client, nil
+}
+
+func newClient(address string, options []Option) *AMIClient {
+	client := &AMIClient{
 		address:  address,
-		amiUser:  user,
-		amiPass:  secret,
 		opNumber: 0,
 		opPrefix: "r",
+		session:  newSession(),
+		dial:     net.Dial,
 
 		response: make(map[string]chan *AMIResponse),
 		raw:      make(chan textproto.MIMEHeader),
 		closing:  make(chan chan error),
+		done:     make(chan struct{}),
+		stopping: make(chan struct{}),
+		ready:    make(chan struct{}),
 
 		Events: make(chan *AMIEvent),
 		Errors: make(chan error),
-		Fatal:  make(chan error),
+		Fatal:  make(chan error, 1),
 	}
-	if err = client.bind(); err != nil {
-		return nil, err
+	for _, option := range options {
+		option(client)
 	}
-	client.run()
-	if user != "" {
-		return client, client.login(user, secret)
+	if client.batchSize > 0 {
+		client.writes = make(chan writeRequest)
 	}
-	return client, nil
+	return client
 }
 
 // Login authenticate to AMI
 func (client *AMIClient) login(username, password string) error {
 	response, err := client.Action("Login", Params{"Username": username, "Secret": password})
 	if err != nil {
+		client.log().Error("login failed", "user", username, "error", err)
 		return err
 	}
 
 	if (*response).Status == "Error" {
+		client.log().Error("login rejected", "user", username, "message", (*response).Params["Message"])
 		return errors.New((*response).Params["Message"])
 	}
+	client.log().Info("logged in", "user", username)
+	client.authenticated()
 
 	client.amiUser = username
 	client.amiPass = password
 	return nil
 }
 
-// Close the connection to AMI
+// Close the connection to AMI, waiting up to 5 seconds for the Logoff
 func (client *AMIClient) Close() error {
-	if _, err := client.Action("Logoff", nil); err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return client.Shutdown(ctx)
+}
+
+// Shutdown stop the client gracefully: it waits until the actions sent are
+// answered or ctx is done, sends Logoff, closes the connection and then
+// Events and Errors. Actions still waiting fail with ErrClosed. Shutdown is
+// safe to call many times, concurrently and after the connection was lost.
+func (client *AMIClient) Shutdown(ctx context.Context) error {
+	client.stopOnce.Do(func() { close(client.stopping) })
+	select {
+	case <-client.done:
+		return nil
+	default:
+	}
+	client.setState(StateShuttingDown, "shutdown")
+
+	client.mu.Lock()
+	drained := client.drained
+	if drained == nil && len(client.response) > 0 {
+		drained = make(chan struct{})
+		client.drained = drained
+	}
+	client.mu.Unlock()
+	if drained != nil {
+		select {
+		case <-drained:
+		case <-ctx.Done():
+		case <-client.done:
+		}
+	}
+
+	if ctx.Err() == nil {
+		if resp, err := client.AsyncAction("Logoff", nil); err == nil {
+			select {
+			case <-resp:
+			case <-ctx.Done():
+			case <-client.done:
+			}
+		}
+	}
+
+	errc := make(chan error, 1)
+	select {
+	case client.closing <- errc:
+		err := <-errc
+		client.log().Info("connection closed", "error", err)
 		return err
+	case <-client.done:
+		return nil
 	}
-	errc := make(chan error)
-	client.closing <- errc
-	return <-errc
 }
 
-//  create a new connection to AMI
-func (client *AMIClient) bind() (err error) {
-	var rwc io.ReadWriteCloser
-	rwc, err = net.Dial("tcp", client.address)
+// log return the logger of the client, discarding when none was set
+func (client *AMIClient) log() *slog.Logger {
+	if client.logger == nil {
+		return discard
+	}
+	return client.logger
+}
 
-	if err != nil {
-		return err
+// measure return the metrics of the client, discarding when none was set
+func (client *AMIClient) measure() Metrics {
+	if client.metrics == nil {
+		return noMetrics{}
 	}
+	return client.metrics
+}
 
-	conn := textproto.NewConn(rwc)
-	label, err := conn.ReadLine()
-	if err != nil {
-		return err
+// Version of the AMI protocol announced in the banner, e.g. 5.0.2
+func (client *AMIClient) Version() string {
+	return client.version
+}
+
+// bind the transport to the client, checking the banner when the transport
+// has one
+func (client *AMIClient) bind(transport Transport) error {
+	if t, ok := transport.(interface{ SetWriteTimeout(time.Duration) }); ok && client.writeTimeout > 0 {
+		t.SetWriteTimeout(client.writeTimeout)
 	}
 
-	if strings.Contains(label, "Asterisk Call Manager") != true {
-		return ErrNotAMI
+	if t, ok := transport.(interface{ ReadBanner() (string, error) }); ok {
+		banner, err := t.ReadBanner()
+		if err != nil {
+			return err
+		}
+		if client.version, err = parseBanner(banner); err != nil {
+			return err
+		}
 	}
 
-	client.conn = conn
+	client.logger = client.log().With("server", client.address, "session", client.session)
+	client.logger.Info("connected", "version", client.version)
+
+	if conn, ok := transport.(MIMEReadWriteCloser); ok {
+		client.conn = conn
+	} else {
+		client.conn = transportConn{transport}
+	}
+	if client.recorder != nil {
+		client.conn = &hookConn{client.conn, client.recorder.Record}
+	}
+	if client.tracer != nil {
+		client.conn = &hookConn{client.conn, traceHook(client.tracer)}
+	}
 	return nil
 }
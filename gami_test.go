@@ -5,6 +5,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"net/textproto"
 	"testing"
+	"time"
 )
 
 type MockMIMEConn struct {
@@ -81,3 +82,57 @@ func TestMain(t *testing.T) {
 
 	client.main()
 }
+
+func TestMarshalAMI(t *testing.T) {
+	ev := AMIEvent{
+		ID:        "Hangup",
+		Privilege: []string{"call", "all"},
+		Params:    Params{"Channel": "SIP/100", "Cause": "16"},
+	}
+	assert.Equal(t, "Event: Hangup\r\nPrivilege: call,all\r\nCause: 16\r\nChannel: SIP/100\r\n\r\n", string(ev.MarshalAMI()))
+}
+
+func TestEventMeta(t *testing.T) {
+	client := AMIClient{session: "abc"}
+	mock := MockMIMEConn{}
+
+	client.conn = &mock
+	client.raw = make(chan textproto.MIMEHeader)
+	client.closing = make(chan chan error)
+	client.Events = make(chan *AMIEvent)
+	client.Errors = make(chan error)
+
+	go func() {
+		for i := 1; i <= 2; i++ {
+			event := textproto.MIMEHeader{}
+			event.Set("Event", "TestEvent")
+			event.Set("Timestamp", "1400000000.123456")
+			client.raw <- event
+
+			ev := <-client.Events
+			assert.Equal(t, uint64(i), ev.Sequence)
+			assert.Equal(t, "abc", ev.Session)
+			assert.Equal(t, time.Unix(1400000000, 123456000), ev.Timestamp)
+			assert.False(t, ev.Received.IsZero())
+		}
+
+		errc := make(chan error)
+		client.closing <- errc
+		<-errc
+	}()
+
+	client.main()
+}
+
+func TestParseTimestamp(t *testing.T) {
+	ts, err := parseTimestamp("1400000000")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Unix(1400000000, 0), ts)
+
+	ts, err = parseTimestamp("1400000000.5")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Unix(1400000000, 500000000), ts)
+
+	_, err = parseTimestamp("now")
+	assert.Error(t, err)
+}
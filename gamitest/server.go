@@ -0,0 +1,387 @@
+// Package gamitest provides an in-process fake Asterisk AMI server for end
+// to end tests of gami and of the code using it.
+//
+//	srv := gamitest.NewServer()
+//	defer srv.Close()
+//	srv.AddUser("admin", "secret")
+//	srv.Respond("CoreStatus", gamitest.Response("Success", "CoreCurrentCalls", "0"))
+//
+//	ami, err := gami.Connect(srv.Addr, "admin", "secret")
+package gamitest
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xytis/gami"
+	"github.com/xytis/gami/internal/wire"
+)
+
+// DefaultBanner sent to every connection unless Server.Banner is changed
+const DefaultBanner = "Asterisk Call Manager/5.0.2"
+
+// Action received by the server
+type Action struct {
+	// Name of the action, the Action header
+	Name string
+	// Headers of the action in the order received, Action included
+	Headers gami.Headers
+	// Conn the action was received on
+	Conn *Conn
+}
+
+// ActionID of the action, empty when not sent
+func (a *Action) ActionID() string {
+	return a.Headers.Get("ActionID")
+}
+
+// Handler answer an action with the frames to send back, frames without
+// ActionID get the one of the action
+type Handler func(action *Action) []gami.Headers
+
+// Server is a fake AMI server listening on a local TCP port
+type Server struct {
+	// Addr the server listens on, host:port
+	Addr string
+	// Banner sent on connect, change it before the first connection
+	Banner string
+
+	listener net.Listener
+
+	mu       sync.Mutex
+	users    map[string]string
+	handlers map[string]Handler
+	delays   map[string]time.Duration
+	conns    map[*Conn]bool
+	actions  []*Action
+	booted   bool
+	wg       sync.WaitGroup
+}
+
+// NewServer start a server on a random local port. Login accepts anyone
+// until AddUser is called, Logoff, Ping, Events and Filter are answered,
+// every other action gets an error unless scripted with Handle or Respond.
+func NewServer() *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("gamitest: failed to listen: " + err.Error())
+	}
+	srv := &Server{
+		Addr:     listener.Addr().String(),
+		Banner:   DefaultBanner,
+		listener: listener,
+		users:    make(map[string]string),
+		handlers: make(map[string]Handler),
+		delays:   make(map[string]time.Duration),
+		conns:    make(map[*Conn]bool),
+	}
+	srv.Handle("Login", srv.login)
+	srv.Handle("Logoff", logoff)
+	srv.Respond("Ping", Response("Success", "Ping", "Pong"))
+	srv.Handle("Events", events)
+	srv.Handle("Filter", filter)
+	srv.wg.Add(1)
+	go srv.serve()
+	return srv
+}
+
+// Close stop listening and drop every connection
+func (srv *Server) Close() {
+	srv.listener.Close()
+	srv.DropConnections()
+	srv.wg.Wait()
+}
+
+// AddUser allow the user to login with secret
+func (srv *Server) AddUser(user, secret string) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.users[user] = secret
+}
+
+// Handle script the answer to an action, names are case insensitive
+func (srv *Server) Handle(action string, handler Handler) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.handlers[strings.ToLower(action)] = handler
+}
+
+// Respond answer the action always with the same frames
+func (srv *Server) Respond(action string, frames ...gami.Headers) {
+	srv.Handle(action, func(*Action) []gami.Headers {
+		return frames
+	})
+}
+
+// Boot make the server send FullyBooted after every accepted login, as a
+// booted Asterisk does
+func (srv *Server) Boot() {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.booted = true
+}
+
+// Delay the answers to the action
+func (srv *Server) Delay(action string, delay time.Duration) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.delays[strings.ToLower(action)] = delay
+}
+
+// Emit send an event to every logged in connection whose event mask and
+// filters let it through
+func (srv *Server) Emit(event gami.Headers) {
+	for _, conn := range srv.Conns() {
+		if conn.LoggedIn() && conn.wants(event) {
+			conn.Send(event)
+		}
+	}
+}
+
+// DropConnections close every connection without Logoff
+func (srv *Server) DropConnections() {
+	for _, conn := range srv.Conns() {
+		conn.Close()
+	}
+}
+
+// Conns return the open connections
+func (srv *Server) Conns() []*Conn {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	conns := make([]*Conn, 0, len(srv.conns))
+	for conn := range srv.conns {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+// Actions return every action received so far, in order
+func (srv *Server) Actions() []*Action {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return append([]*Action(nil), srv.actions...)
+}
+
+// WaitAction wait until an action with the name is received
+func (srv *Server) WaitAction(name string, timeout time.Duration) (*Action, bool) {
+	deadline := time.Now().Add(timeout)
+	for {
+		for _, action := range srv.Actions() {
+			if strings.EqualFold(action.Name, name) {
+				return action, true
+			}
+		}
+		if time.Now().After(deadline) {
+			return nil, false
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (srv *Server) serve() {
+	defer srv.wg.Done()
+	for {
+		nc, err := srv.listener.Accept()
+		if err != nil {
+			return
+		}
+		conn := &Conn{nc: nc, srv: srv}
+		srv.mu.Lock()
+		srv.conns[conn] = true
+		srv.mu.Unlock()
+		srv.wg.Add(1)
+		go conn.serve()
+	}
+}
+
+func (srv *Server) login(action *Action) []gami.Headers {
+	srv.mu.Lock()
+	secret, ok := srv.users[action.Headers.Get("Username")]
+	open := len(srv.users) == 0
+	booted := srv.booted
+	srv.mu.Unlock()
+
+	if !open && (!ok || secret != action.Headers.Get("Secret")) {
+		return []gami.Headers{Response("Error", "Message", "Authentication failed")}
+	}
+	action.Conn.setLoggedIn()
+	if mask := action.Headers.Get("Events"); mask != "" {
+		action.Conn.setEventMask(mask)
+	}
+	frames := []gami.Headers{Response("Success", "Message", "Authentication accepted")}
+	if booted {
+		frames = append(frames, Event("FullyBooted", "Privilege", "system,all", "Status", "Fully Booted"))
+	}
+	return frames
+}
+
+// events set the event mask of the connection, "off", "on" or a list of
+// classes matched against the Privilege of the events
+func events(action *Action) []gami.Headers {
+	mask := action.Headers.Get("EventMask")
+	action.Conn.setEventMask(mask)
+	if strings.EqualFold(mask, "off") {
+		return []gami.Headers{Response("Success", "Events", "Off")}
+	}
+	return []gami.Headers{Response("Success", "Events", "On")}
+}
+
+// filter add a regular expression matched against the lines of the events,
+// filters starting with ! drop the events they match
+func filter(action *Action) []gami.Headers {
+	if op := action.Headers.Get("Operation"); op != "" && !strings.EqualFold(op, "Add") {
+		return []gami.Headers{Response("Error", "Message", "Unknown operation")}
+	}
+	action.Conn.mu.Lock()
+	err := action.Conn.eventFilter.AddFilter(action.Headers.Get("Filter"))
+	action.Conn.mu.Unlock()
+	if err != nil {
+		return []gami.Headers{Response("Error", "Message", "Filter Not Added")}
+	}
+	return []gami.Headers{Response("Success", "Message", "Filter Added Successfully")}
+}
+
+func logoff(action *Action) []gami.Headers {
+	action.Conn.closeAfterReply = true
+	return []gami.Headers{Response("Goodbye", "Message", "Thanks for all the fish.")}
+}
+
+// Conn is a client connected to the server
+type Conn struct {
+	nc  net.Conn
+	srv *Server
+
+	mu              sync.Mutex
+	loggedIn        bool
+	closeAfterReply bool
+	eventFilter     wire.EventFilter
+}
+
+// Send write a frame on the connection
+func (conn *Conn) Send(frame gami.Headers) error {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	_, err := conn.nc.Write(wire.Marshal(frame))
+	return err
+}
+
+// Close drop the connection
+func (conn *Conn) Close() error {
+	return conn.nc.Close()
+}
+
+// LoggedIn tells if the connection passed Login
+func (conn *Conn) LoggedIn() bool {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	return conn.loggedIn
+}
+
+func (conn *Conn) setEventMask(mask string) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	conn.eventFilter.SetMask(mask)
+}
+
+// wants tells if the event mask and filters of the connection let the
+// event through
+func (conn *Conn) wants(event gami.Headers) bool {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	return conn.eventFilter.Wants(strings.Split(event.Get("Privilege"), ","), wire.Marshal(event))
+}
+
+func (conn *Conn) setLoggedIn() {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	conn.loggedIn = true
+}
+
+func (conn *Conn) serve() {
+	defer conn.srv.wg.Done()
+	defer func() {
+		conn.srv.mu.Lock()
+		delete(conn.srv.conns, conn)
+		conn.srv.mu.Unlock()
+		conn.nc.Close()
+	}()
+
+	if _, err := conn.nc.Write([]byte(conn.srv.Banner + "\r\n")); err != nil {
+		return
+	}
+	reader := bufio.NewReader(conn.nc)
+	for {
+		headers, err := wire.ReadAction(reader)
+		if err != nil {
+			return
+		}
+		conn.handle(&Action{Name: headers.Get("Action"), Headers: headers, Conn: conn})
+		if conn.closeAfterReply {
+			return
+		}
+	}
+}
+
+func (conn *Conn) handle(action *Action) {
+	srv := conn.srv
+	name := strings.ToLower(action.Name)
+	srv.mu.Lock()
+	srv.actions = append(srv.actions, action)
+	handler, ok := srv.handlers[name]
+	delay := srv.delays[name]
+	srv.mu.Unlock()
+
+	var frames []gami.Headers
+	switch {
+	case name != "login" && name != "challenge" && !conn.LoggedIn():
+		frames = []gami.Headers{Response("Error", "Message", "Authentication Required")}
+	case ok:
+		frames = handler(action)
+	default:
+		frames = []gami.Headers{Response("Error", "Message", "Invalid/unknown command")}
+	}
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	for _, frame := range frames {
+		if id := action.ActionID(); id != "" && frame.Get("ActionID") == "" {
+			frame = append(append(gami.Headers(nil), frame...), gami.Header{Key: "ActionID", Value: id})
+		}
+		if err := conn.Send(frame); err != nil {
+			return
+		}
+	}
+}
+
+// Response build a response frame, kv are header keys and values
+func Response(status string, kv ...string) gami.Headers {
+	return frame("Response", status, kv)
+}
+
+// Event build an event frame, kv are header keys and values
+func Event(name string, kv ...string) gami.Headers {
+	return frame("Event", name, kv)
+}
+
+// EventList build the frames of an action answered with a list of events,
+// e.g. SIPpeers, closed by an event named complete
+func EventList(items []gami.Headers, complete string) []gami.Headers {
+	frames := []gami.Headers{Response("Success", "EventList", "start", "Message", "Events will follow")}
+	frames = append(frames, items...)
+	frames = append(frames, Event(complete, "EventList", "Complete", "ListItems", strconv.Itoa(len(items))))
+	return frames
+}
+
+func frame(key, value string, kv []string) gami.Headers {
+	frame := gami.Headers{{Key: key, Value: value}}
+	for i := 0; i+1 < len(kv); i += 2 {
+		frame.Add(kv[i], kv[i+1])
+	}
+	return frame
+}
@@ -0,0 +1,192 @@
+package gamitest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xytis/gami"
+)
+
+func TestServerLogin(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	srv.AddUser("admin", "secret")
+
+	if _, err := gami.Connect(srv.Addr, "admin", "wrong"); err == nil || err.Error() != "Authentication failed" {
+		t.Fatal("Login with a wrong secret:", err)
+	}
+
+	ami, err := gami.Connect(srv.Addr, "admin", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ami.Version() != "5.0.2" {
+		t.Fatal("Banner version:", ami.Version())
+	}
+
+	login, ok := srv.WaitAction("Login", time.Second)
+	if !ok || login.Headers.Get("Username") != "admin" || login.ActionID() == "" {
+		t.Fatal("Login not recorded:", login)
+	}
+}
+
+func TestServerAuthenticationRequired(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	ami, err := gami.Connect(srv.Addr, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := ami.Action("Ping", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Status != "Error" || response.Params["Message"] != "Authentication Required" {
+		t.Fatal("Action before Login:", response)
+	}
+}
+
+func TestServerScript(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	srv.Respond("CoreStatus", Response("Success", "CoreCurrentCalls", "3"))
+	srv.Handle("Getvar", func(action *Action) []gami.Headers {
+		return []gami.Headers{Response("Success", "Variable", action.Headers.Get("Variable"), "Value", "42")}
+	})
+
+	ami, err := gami.Connect(srv.Addr, "admin", "any")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := ami.Action("CoreStatus", nil)
+	if err != nil || response.Params["Corecurrentcalls"] != "3" {
+		t.Fatal("Scripted response:", response, err)
+	}
+	response, err = ami.Action("Getvar", gami.Params{"Variable": "ANSWER"})
+	if err != nil || response.Params["Value"] != "42" || response.Params["Variable"] != "ANSWER" {
+		t.Fatal("Scripted handler:", response, err)
+	}
+	response, err = ami.Action("Nope", nil)
+	if err != nil || response.Status != "Error" {
+		t.Fatal("Unknown action:", response, err)
+	}
+}
+
+func TestServerEventList(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	srv.Respond("SIPpeers", EventList([]gami.Headers{
+		Event("PeerEntry", "ObjectName", "100"),
+		Event("PeerEntry", "ObjectName", "101"),
+	}, "PeerlistComplete")...)
+
+	ami, err := gami.Connect(srv.Addr, "admin", "any")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := ami.Action("SIPpeers", gami.Params{"ActionID": "peers"})
+	if err != nil || response.Params["Eventlist"] != "start" {
+		t.Fatal("EventList response:", response, err)
+	}
+
+	var names []string
+	for len(names) < 3 {
+		select {
+		case ev := <-ami.Events:
+			if ev.Params["Actionid"] != "peers" {
+				t.Fatal("EventList event without ActionID:", ev)
+			}
+			names = append(names, ev.ID+":"+ev.Params["Objectname"])
+		case <-time.After(time.Second):
+			t.Fatal("EventList events not received:", names)
+		}
+	}
+	if names[0] != "PeerEntry:100" || names[1] != "PeerEntry:101" || names[2] != "PeerlistComplete:" {
+		t.Fatal("EventList events:", names)
+	}
+}
+
+func TestServerEmitAndDrop(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	ami, err := gami.Connect(srv.Addr, "admin", "any")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.Emit(Event("FullyBooted", "Privilege", "system,all", "Status", "Fully Booted"))
+	select {
+	case ev := <-ami.Events:
+		if ev.ID != "FullyBooted" || ev.Params["Status"] != "Fully Booted" {
+			t.Fatal("Emitted event:", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Emitted event not received")
+	}
+
+	srv.DropConnections()
+	select {
+	case <-ami.Fatal:
+	case <-time.After(time.Second):
+		t.Fatal("Dropped connection not detected")
+	}
+}
+
+func TestServerDelay(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	srv.Delay("Ping", 100*time.Millisecond)
+
+	ami, err := gami.Connect(srv.Addr, "admin", "any")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := ami.Action("Ping", nil); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < 100*time.Millisecond {
+		t.Fatal("Ping not delayed")
+	}
+}
+
+func TestServerEventMask(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	ami, err := gami.Connect(srv.Addr, "admin", "any")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ami.Action("Events", gami.Params{"EventMask": "call"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ami.Action("Filter", gami.Params{"Operation": "Add", "Filter": "!Channel: Local/"}); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.Emit(Event("PeerStatus", "Privilege", "system,all"))
+	srv.Emit(Event("Newchannel", "Privilege", "call,all", "Channel", "Local/100@default-00000001;1"))
+	srv.Emit(Event("Newchannel", "Privilege", "call,all", "Channel", "SIP/100-00000001"))
+	select {
+	case ev := <-ami.Events:
+		if ev.ID != "Newchannel" || ev.Params["Channel"] != "SIP/100-00000001" {
+			t.Fatal("Event mask or filter not applied:", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Event not received")
+	}
+
+	if _, err := ami.Action("Events", gami.Params{"EventMask": "off"}); err != nil {
+		t.Fatal(err)
+	}
+	srv.Emit(Event("Newchannel", "Privilege", "call,all", "Channel", "SIP/101-00000002"))
+	select {
+	case ev := <-ami.Events:
+		t.Fatal("Event received with events off:", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,90 @@
+package gami
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAsyncActionInjection(t *testing.T) {
+	client, mock := newRecordClient()
+
+	for _, params := range []ActionParams{
+		Params{"CallerID": "Alice\r\n\r\nAction: Originate"},
+		Params{"CallerID": "Alice\nAction: Command"},
+		Params{"Caller\r\nID": "Alice"},
+		Params{"Caller: ID": "Alice"},
+		Params{"": "Alice"},
+		Headers{{"Variable", "A=1\x00"}},
+	} {
+		_, err := client.AsyncAction("Originate", params)
+		assert.True(t, errors.Is(err, ErrInvalidHeader), params)
+		var headerErr *HeaderError
+		assert.True(t, errors.As(err, &headerErr), params)
+	}
+
+	_, err := client.AsyncAction("Ping\r\nAction: Command", nil)
+	assert.True(t, errors.Is(err, ErrInvalidHeader))
+
+	// nothing reached the wire and no ActionID was used
+	assert.Equal(t, 0, len(mock.lines))
+	assert.Equal(t, 0, client.opNumber)
+}
+
+// readFrames parse the written lines the way Asterisk does, one action per
+// block of lines ended by an empty line
+func readFrames(lines []string) []map[string][]string {
+	reader := bufio.NewReader(strings.NewReader(strings.Join(lines, "\r\n") + "\r\n"))
+	var frames []map[string][]string
+	frame := map[string][]string{}
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			break
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			frames = append(frames, frame)
+			frame = map[string][]string{}
+			continue
+		}
+		kv := strings.SplitN(line, ":", 2)
+		frame[kv[0]] = append(frame[kv[0]], line)
+	}
+	if len(frame) > 0 {
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+func FuzzAsyncAction(f *testing.F) {
+	f.Add("CallerID", "Alice")
+	f.Add("CallerID", "Alice\r\n\r\nAction: Originate")
+	f.Add("Caller\nID", "x")
+	f.Add("Variable", "A=1\rAction: Command")
+	f.Add("Action", "Logoff")
+	f.Fuzz(func(t *testing.T, key, value string) {
+		client, mock := newRecordClient()
+		_, err := client.AsyncAction("UserEvent", Headers{{key, value}})
+		if err != nil {
+			if !errors.Is(err, ErrInvalidHeader) {
+				t.Fatal("unexpected error:", err)
+			}
+			if len(mock.lines) != 0 {
+				t.Fatal("rejected action reached the wire:", mock.lines)
+			}
+			return
+		}
+		frames := readFrames(mock.lines)
+		if len(frames) != 1 {
+			t.Fatalf("%q: %q created %d frames", key, value, len(frames))
+		}
+		if len(frames[0]["ActionID"]) != 1 {
+			t.Fatalf("%q: %q broke the frame: %q", key, value, mock.lines)
+		}
+	})
+}
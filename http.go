@@ -0,0 +1,294 @@
+package gami
+
+import (
+	"bufio"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Formats of the Asterisk HTTP manager
+const (
+	// HTTPRawman answers with the same text frames as the AMI socket
+	HTTPRawman = "rawman"
+	// HTTPMXML answers with XML, each frame is a generic element
+	HTTPMXML = "mxml"
+)
+
+// ErrHTTPStatus raised when the HTTP manager answers with an unexpected status
+var ErrHTTPStatus = errors.New("Unexpected HTTP status")
+
+// HTTPTransport speaks AMI through the HTTP server built in Asterisk, for
+// boxes that only expose /rawman or /mxml. Actions are sent as query
+// parameters, the session is kept with the mansession_id cookie and events
+// are received by long-polling WaitEvent once logged in.
+type HTTPTransport struct {
+	// WaitTimeout is the timeout asked to WaitEvent, the http.Client timeout
+	// must be longer
+	WaitTimeout time.Duration
+
+	endpoint string
+	format   string
+	client   *http.Client
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	mu      sync.Mutex
+	queue   []textproto.MIMEHeader
+	err     error
+	notify  chan struct{}
+	polling sync.Once
+}
+
+// NewHTTPTransport create a transport for the manager at baseURL, e.g.
+// http://pbx:8088/asterisk/, using the rawman or mxml format. The
+// httpClient is copied, nil uses a plain http.Client, and a cookie jar is
+// added when missing to keep the session.
+func NewHTTPTransport(baseURL string, format string, httpClient *http.Client) (*HTTPTransport, error) {
+	if format != HTTPRawman && format != HTTPMXML {
+		return nil, fmt.Errorf("Unknown HTTP manager format %q", format)
+	}
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	base.Path = strings.TrimSuffix(base.Path, "/") + "/" + format
+
+	client := http.Client{}
+	if httpClient != nil {
+		client = *httpClient
+	}
+	if client.Jar == nil {
+		if client.Jar, err = cookiejar.New(nil); err != nil {
+			return nil, err
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &HTTPTransport{
+		WaitTimeout: 30 * time.Second,
+		endpoint:    base.String(),
+		format:      format,
+		client:      &client,
+		ctx:         ctx,
+		cancel:      cancel,
+		notify:      make(chan struct{}, 1),
+	}, nil
+}
+
+// ConnectHTTP create a client over the HTTP manager at baseURL
+func ConnectHTTP(baseURL string, format string, user string, secret string, options ...Option) (*AMIClient, error) {
+	transport, err := NewHTTPTransport(baseURL, format, nil)
+	if err != nil {
+		return nil, err
+	}
+	client, err := NewClient(transport, user, secret, options...)
+	if client != nil {
+		client.address = baseURL
+	}
+	return client, err
+}
+
+// ReadFrame implements Transport
+func (t *HTTPTransport) ReadFrame() (textproto.MIMEHeader, error) {
+	for {
+		t.mu.Lock()
+		if len(t.queue) > 0 {
+			frame := t.queue[0]
+			t.queue = t.queue[1:]
+			t.mu.Unlock()
+			return frame, nil
+		}
+		err := t.err
+		t.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+
+		select {
+		case <-t.notify:
+		case <-t.ctx.Done():
+			return nil, io.EOF
+		}
+	}
+}
+
+// WriteFrame implements Transport, each action of the frame is one request
+func (t *HTTPTransport) WriteFrame(frame []byte) error {
+	for _, action := range splitActions(frame) {
+		frames, err := t.request(action)
+		if err != nil {
+			return err
+		}
+		t.push(frames...)
+
+		if strings.EqualFold(action.Get("Action"), "Login") {
+			for _, frame := range frames {
+				if frame.Get("Response") == "Success" {
+					t.polling.Do(func() { go t.poll() })
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Close implements Transport, pending requests are canceled
+func (t *HTTPTransport) Close() error {
+	t.cancel()
+	return nil
+}
+
+// poll keep a WaitEvent request open to receive the events
+func (t *HTTPTransport) poll() {
+	for n := 0; ; n++ {
+		actionID := "gami-waitevent-" + strconv.Itoa(n)
+		action := Headers{
+			{"Action", "WaitEvent"},
+			{"Timeout", strconv.Itoa(int(t.WaitTimeout / time.Second))},
+			{"ActionID", actionID},
+		}
+		frames, err := t.request(action)
+		if t.ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			t.fail(err)
+			return
+		}
+		for _, frame := range frames {
+			if frame.Get("ActionID") != actionID {
+				t.push(frame)
+			} else if frame.Get("Response") == "Error" {
+				t.fail(errors.New(frame.Get("Message")))
+				return
+			}
+		}
+	}
+}
+
+func (t *HTTPTransport) push(frames ...textproto.MIMEHeader) {
+	if len(frames) == 0 {
+		return
+	}
+	t.mu.Lock()
+	t.queue = append(t.queue, frames...)
+	t.mu.Unlock()
+	t.wake()
+}
+
+func (t *HTTPTransport) fail(err error) {
+	t.mu.Lock()
+	t.err = err
+	t.mu.Unlock()
+	t.wake()
+}
+
+func (t *HTTPTransport) wake() {
+	select {
+	case t.notify <- struct{}{}:
+	default:
+	}
+}
+
+// request send one action and parse the frames of the answer
+func (t *HTTPTransport) request(action Headers) ([]textproto.MIMEHeader, error) {
+	query := make([]string, 0, len(action))
+	for _, header := range action {
+		query = append(query, url.QueryEscape(header.Key)+"="+url.QueryEscape(header.Value))
+	}
+
+	req, err := http.NewRequestWithContext(t.ctx, "GET", t.endpoint+"?"+strings.Join(query, "&"), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", ErrHTTPStatus, resp.Status)
+	}
+
+	if t.format == HTTPMXML {
+		return parseMXML(resp.Body)
+	}
+	return parseRawman(resp.Body)
+}
+
+// splitActions parse the actions of a frame keeping the order of the headers
+func splitActions(frame []byte) []Headers {
+	var actions []Headers
+	var current Headers
+	for _, line := range strings.Split(string(frame), "\r\n") {
+		if line == "" {
+			if len(current) > 0 {
+				actions = append(actions, current)
+				current = nil
+			}
+			continue
+		}
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		current.Add(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+	}
+	if len(current) > 0 {
+		actions = append(actions, current)
+	}
+	return actions
+}
+
+// parseRawman read the text frames of a rawman answer
+func parseRawman(r io.Reader) ([]textproto.MIMEHeader, error) {
+	reader := textproto.NewReader(bufio.NewReader(r))
+	var frames []textproto.MIMEHeader
+	for {
+		frame, err := reader.ReadMIMEHeader()
+		if len(frame) > 0 {
+			frames = append(frames, frame)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return frames, nil
+		}
+		if err != nil {
+			return frames, err
+		}
+	}
+}
+
+// parseMXML read the generic elements of a mxml answer, attributes are the
+// headers of each frame
+func parseMXML(r io.Reader) ([]textproto.MIMEHeader, error) {
+	decoder := xml.NewDecoder(r)
+	var frames []textproto.MIMEHeader
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return frames, nil
+		}
+		if err != nil {
+			return frames, err
+		}
+		element, ok := token.(xml.StartElement)
+		if !ok || element.Name.Local != "generic" {
+			continue
+		}
+		frame := textproto.MIMEHeader{}
+		for _, attr := range element.Attr {
+			frame.Add(attr.Name.Local, attr.Value)
+		}
+		frames = append(frames, frame)
+	}
+}
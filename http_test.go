@@ -0,0 +1,126 @@
+package gami
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeManager emulates the Asterisk HTTP manager for both formats
+type fakeManager struct {
+	events chan string
+}
+
+func (m *fakeManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	format := strings.TrimPrefix(r.URL.Path, "/asterisk/")
+	query := r.URL.Query()
+	action := strings.ToLower(query.Get("Action"))
+	id := query.Get("ActionID")
+
+	var frames []map[string]string
+	switch {
+	case action == "login":
+		if query.Get("Username") != "admin" || query.Get("Secret") != "secret" {
+			frames = append(frames, map[string]string{"Response": "Error", "ActionID": id, "Message": "Authentication failed"})
+			break
+		}
+		http.SetCookie(w, &http.Cookie{Name: "mansession_id", Value: "abc", Path: "/"})
+		frames = append(frames, map[string]string{"Response": "Success", "ActionID": id, "Message": "Authentication accepted"})
+	case !m.authenticated(r):
+		frames = append(frames, map[string]string{"Response": "Error", "ActionID": id, "Message": "Permission denied"})
+	case action == "ping":
+		frames = append(frames, map[string]string{"Response": "Success", "ActionID": id, "Ping": "Pong"})
+	case action == "waitevent":
+		frames = append(frames, map[string]string{"Response": "Success", "ActionID": id, "Message": "Waiting for Event completed."})
+		select {
+		case name := <-m.events:
+			frames = append(frames, map[string]string{"Event": name, "Privilege": "user,all"})
+		case <-time.After(50 * time.Millisecond):
+		case <-r.Context().Done():
+			return
+		}
+		frames = append(frames, map[string]string{"Event": "WaitEventComplete", "ActionID": id})
+	}
+
+	for _, frame := range frames {
+		if format == HTTPMXML {
+			fmt.Fprint(w, "<ajax-response><response type='object' id='unknown'><generic")
+			for k, v := range frame {
+				fmt.Fprintf(w, " %s='%s'", strings.ToLower(k), v)
+			}
+			fmt.Fprint(w, " /></response></ajax-response>\r\n")
+			continue
+		}
+		for k, v := range frame {
+			fmt.Fprintf(w, "%s: %s\r\n", k, v)
+		}
+		fmt.Fprint(w, "\r\n")
+	}
+}
+
+func (m *fakeManager) authenticated(r *http.Request) bool {
+	cookie, err := r.Cookie("mansession_id")
+	return err == nil && cookie.Value == "abc"
+}
+
+func testHTTPTransport(t *testing.T, format string) {
+	manager := &fakeManager{events: make(chan string, 1)}
+	server := httptest.NewServer(manager)
+	defer server.Close()
+
+	client, err := ConnectHTTP(server.URL+"/asterisk/", format, "admin", "secret")
+	assert.NoError(t, err)
+	defer client.conn.Close()
+
+	response, err := client.Action("Ping", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "Success", response.Status)
+	assert.Equal(t, "Pong", response.Params["Ping"])
+
+	manager.events <- "UserEvent"
+	select {
+	case ev := <-client.Events:
+		assert.Equal(t, "UserEvent", ev.ID)
+		assert.Equal(t, []string{"user", "all"}, ev.Privilege)
+	case <-time.After(time.Second):
+		t.Fatal("no event received through WaitEvent")
+	}
+}
+
+func TestHTTPTransportRawman(t *testing.T) {
+	testHTTPTransport(t, HTTPRawman)
+}
+
+func TestHTTPTransportMXML(t *testing.T) {
+	testHTTPTransport(t, HTTPMXML)
+}
+
+func TestHTTPTransportLoginFailed(t *testing.T) {
+	server := httptest.NewServer(&fakeManager{})
+	defer server.Close()
+
+	_, err := ConnectHTTP(server.URL+"/asterisk/", HTTPRawman, "admin", "wrong")
+	assert.Equal(t, "Authentication failed", fmt.Sprint(err))
+}
+
+func TestHTTPTransportFormat(t *testing.T) {
+	_, err := NewHTTPTransport("http://pbx:8088/asterisk/", "json", nil)
+	assert.Error(t, err)
+
+	transport, err := NewHTTPTransport("http://pbx:8088/asterisk", HTTPMXML, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://pbx:8088/asterisk/mxml", transport.endpoint)
+}
+
+func TestSplitActions(t *testing.T) {
+	actions := splitActions([]byte("Action: Ping\r\nActionID: 1\r\n\r\nAction: Originate\r\nVariable: A=1\r\nVariable: B=2\r\n\r\n"))
+	assert.Equal(t, []Headers{
+		{{"Action", "Ping"}, {"ActionID", "1"}},
+		{{"Action", "Originate"}, {"Variable", "A=1"}, {"Variable", "B=2"}},
+	}, actions)
+}
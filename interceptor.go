@@ -0,0 +1,121 @@
+package gami
+
+import (
+	"log/slog"
+	"time"
+)
+
+// ActionRequest is an action going through the interceptors, they may
+// change it before calling the next handler. The ActionID is already set.
+type ActionRequest struct {
+	Action  string
+	Headers Headers
+}
+
+// ActionID of the request
+func (req *ActionRequest) ActionID() string {
+	return req.Headers.Get("ActionID")
+}
+
+// ActionHandler sends an action, the response is received on the channel
+type ActionHandler func(req *ActionRequest) (<-chan *AMIResponse, error)
+
+// ActionInterceptor runs around every AsyncAction and Action, it calls next
+// to go on with the action or returns an error to stop it
+type ActionInterceptor func(req *ActionRequest, next ActionHandler) (<-chan *AMIResponse, error)
+
+// EventHandler delivers an event to the Events channel
+type EventHandler func(event *AMIEvent)
+
+// EventInterceptor runs for every event read, it calls next to deliver the
+// event, which may be changed, or skips it to drop the event. Interceptors
+// run on the goroutine reading the connection and must not block.
+type EventInterceptor func(event *AMIEvent, next EventHandler)
+
+// chainActions build the handler running the interceptors in order, the
+// first one is the outermost
+func chainActions(interceptors []ActionInterceptor, handler ActionHandler) ActionHandler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], handler
+		handler = func(req *ActionRequest) (<-chan *AMIResponse, error) {
+			return interceptor(req, next)
+		}
+	}
+	return handler
+}
+
+// chainEvents build the handler running the interceptors in order, the
+// first one is the outermost
+func chainEvents(interceptors []EventInterceptor, handler EventHandler) EventHandler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], handler
+		handler = func(event *AMIEvent) {
+			interceptor(event, next)
+		}
+	}
+	return handler
+}
+
+// OnResponse call fn with the response of resp when it's received, the
+// returned channel yields the same response. Helper for interceptors that
+// need the response, fn runs on its own goroutine.
+func OnResponse(resp <-chan *AMIResponse, fn func(response *AMIResponse)) <-chan *AMIResponse {
+	out := make(chan *AMIResponse, 1)
+	go func() {
+		defer close(out)
+		if response, ok := <-resp; ok {
+			fn(response)
+			out <- response
+		}
+	}()
+	return out
+}
+
+// LogActions log every action and its response at debug level, failures
+// at error level. Secret and Key values are masked.
+func LogActions(logger *slog.Logger) ActionInterceptor {
+	return func(req *ActionRequest, next ActionHandler) (<-chan *AMIResponse, error) {
+		start := time.Now()
+		logger.Debug("action", "action", req.Action, "action_id", req.ActionID(), headerGroup(redact(req.Headers)))
+		resp, err := next(req)
+		if err != nil {
+			logger.Error("action failed", "action", req.Action, "action_id", req.ActionID(), "error", err)
+			return nil, err
+		}
+		return OnResponse(resp, func(response *AMIResponse) {
+			logger.Debug("response", "action", req.Action, "action_id", req.ActionID(), "status", response.Status, "latency", time.Since(start))
+		}), nil
+	}
+}
+
+// TimeActions call observe with the time taken by every action to be
+// answered, actions not answered are not observed
+func TimeActions(observe func(action string, latency time.Duration)) ActionInterceptor {
+	return func(req *ActionRequest, next ActionHandler) (<-chan *AMIResponse, error) {
+		start := time.Now()
+		resp, err := next(req)
+		if err != nil {
+			return nil, err
+		}
+		return OnResponse(resp, func(*AMIResponse) {
+			observe(req.Action, time.Since(start))
+		}), nil
+	}
+}
+
+// LogEvents log every event at debug level
+func LogEvents(logger *slog.Logger) EventInterceptor {
+	return func(event *AMIEvent, next EventHandler) {
+		logger.Debug("event", "event", event.ID, "sequence", event.Sequence, "privilege", event.Privilege)
+		next(event)
+	}
+}
+
+// headerGroup log headers as a group of attributes
+func headerGroup(headers Headers) slog.Attr {
+	attrs := make([]interface{}, 0, len(headers))
+	for _, header := range headers {
+		attrs = append(attrs, slog.String(header.Key, header.Value))
+	}
+	return slog.Group("headers", attrs...)
+}
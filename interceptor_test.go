@@ -0,0 +1,138 @@
+package gami_test
+
+import (
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xytis/gami"
+	"github.com/xytis/gami/gamitest"
+)
+
+func TestActionInterceptors(t *testing.T) {
+	srv := gamitest.NewServer()
+	defer srv.Close()
+
+	var mu sync.Mutex
+	var calls []string
+	trace := func(name string) gami.ActionInterceptor {
+		return func(req *gami.ActionRequest, next gami.ActionHandler) (<-chan *gami.AMIResponse, error) {
+			mu.Lock()
+			calls = append(calls, name+" "+req.Action+" "+req.ActionID())
+			mu.Unlock()
+			return next(req)
+		}
+	}
+	audit := func(req *gami.ActionRequest, next gami.ActionHandler) (<-chan *gami.AMIResponse, error) {
+		req.Headers.Set("Account", "billing")
+		return next(req)
+	}
+	denied := errors.New("denied")
+	deny := func(req *gami.ActionRequest, next gami.ActionHandler) (<-chan *gami.AMIResponse, error) {
+		if req.Action == "Command" {
+			return nil, denied
+		}
+		return next(req)
+	}
+
+	ami, err := gami.Connect(srv.Addr, "admin", "secret",
+		gami.WithActionInterceptors(trace("outer"), trace("inner")),
+		gami.WithActionInterceptors(audit, deny))
+	assert.NoError(t, err)
+
+	_, err = ami.Action("Ping", gami.Params{"ActionID": "ping-1"})
+	assert.NoError(t, err)
+	ping, ok := srv.WaitAction("Ping", time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, "billing", ping.Headers.Get("Account"))
+
+	_, err = ami.Action("Command", gami.Params{"ActionID": "cmd-1", "Command": "core restart now"})
+	assert.Equal(t, denied, err)
+	_, ok = srv.WaitAction("Command", 50*time.Millisecond)
+	assert.False(t, ok)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{
+		"outer Login r0", "inner Login r0",
+		"outer Ping ping-1", "inner Ping ping-1",
+		"outer Command cmd-1", "inner Command cmd-1",
+	}, calls)
+}
+
+func TestActionInterceptorInjection(t *testing.T) {
+	srv := gamitest.NewServer()
+	defer srv.Close()
+
+	inject := func(req *gami.ActionRequest, next gami.ActionHandler) (<-chan *gami.AMIResponse, error) {
+		if req.Action == "Ping" {
+			req.Headers.Add("Account", "x\r\nAction: Command")
+		}
+		return next(req)
+	}
+	ami, err := gami.Connect(srv.Addr, "admin", "secret", gami.WithActionInterceptors(inject))
+	assert.NoError(t, err)
+	_, err = ami.Action("Ping", nil)
+	assert.True(t, errors.Is(err, gami.ErrInvalidHeader))
+}
+
+func TestEventInterceptors(t *testing.T) {
+	srv := gamitest.NewServer()
+	defer srv.Close()
+
+	drop := func(event *gami.AMIEvent, next gami.EventHandler) {
+		if event.ID != "VarSet" {
+			next(event)
+		}
+	}
+	label := func(event *gami.AMIEvent, next gami.EventHandler) {
+		event.Params["Node"] = "pbx1"
+		next(event)
+	}
+	ami, err := gami.Connect(srv.Addr, "admin", "secret", gami.WithEventInterceptors(drop, label))
+	assert.NoError(t, err)
+
+	srv.Emit(gamitest.Event("VarSet", "Variable", "A"))
+	srv.Emit(gamitest.Event("Hangup", "Channel", "SIP/100-00000001"))
+	ev := <-ami.Events
+	assert.Equal(t, "Hangup", ev.ID)
+	assert.Equal(t, "pbx1", ev.Params["Node"])
+}
+
+func TestBuiltinInterceptors(t *testing.T) {
+	srv := gamitest.NewServer()
+	defer srv.Close()
+	srv.Delay("Ping", 20*time.Millisecond)
+
+	var out syncBuffer
+	logger := slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	latencies := make(chan time.Duration, 2)
+	timer := gami.TimeActions(func(action string, latency time.Duration) {
+		if action == "Ping" {
+			latencies <- latency
+		}
+	})
+
+	ami, err := gami.Connect(srv.Addr, "admin", "topsecret",
+		gami.WithActionInterceptors(gami.LogActions(logger), timer),
+		gami.WithEventInterceptors(gami.LogEvents(logger)))
+	assert.NoError(t, err)
+	response, err := ami.Action("Ping", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "Success", response.Status)
+	assert.True(t, <-latencies >= 20*time.Millisecond)
+
+	srv.Emit(gamitest.Event("Hangup"))
+	<-ami.Events
+
+	log := out.String()
+	assert.True(t, strings.Contains(log, "msg=action action=Login action_id=r0"), log)
+	assert.True(t, strings.Contains(log, "headers.Secret=********"), log)
+	assert.False(t, strings.Contains(log, "topsecret"), log)
+	assert.True(t, strings.Contains(log, "msg=response action=Ping action_id=r1 status=Success"), log)
+	assert.True(t, strings.Contains(log, "msg=event event=Hangup sequence=1"), log)
+}
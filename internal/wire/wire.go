@@ -0,0 +1,105 @@
+// Package wire holds the server side of the AMI protocol shared by the fake
+// server of gamitest and the proxy: reading the actions, writing the frames
+// and matching the events against the event mask and filters of a client.
+package wire
+
+import (
+	"bufio"
+	"regexp"
+	"strings"
+
+	"github.com/xytis/gami"
+)
+
+// ReadAction read the lines of an action up to the empty line, empty lines
+// before the action are skipped
+func ReadAction(reader *bufio.Reader) (gami.Headers, error) {
+	var headers gami.Headers
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			if len(headers) == 0 {
+				continue
+			}
+			return headers, nil
+		}
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		headers.Add(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+	}
+}
+
+// Marshal encode a frame in the wire format
+func Marshal(frame gami.Headers) []byte {
+	var b strings.Builder
+	for _, header := range frame {
+		b.WriteString(header.Key + ": " + header.Value + "\r\n")
+	}
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
+
+// EventFilter is the event mask and filters a client sets with the Events
+// and Filter actions, the zero value lets every event through. The caller
+// guards it.
+type EventFilter struct {
+	mask    string
+	filters []filter
+}
+
+type filter struct {
+	re      *regexp.Regexp
+	exclude bool
+}
+
+// SetMask set the event mask, "off", "on" or a list of classes matched
+// against the Privilege of the events
+func (f *EventFilter) SetMask(mask string) {
+	f.mask = mask
+}
+
+// AddFilter add a regular expression matched against the lines of the
+// events, filters starting with ! drop the events they match
+func (f *EventFilter) AddFilter(expr string) error {
+	exclude := strings.HasPrefix(expr, "!")
+	re, err := regexp.Compile(strings.TrimPrefix(expr, "!"))
+	if err != nil {
+		return err
+	}
+	f.filters = append(f.filters, filter{re, exclude})
+	return nil
+}
+
+// Wants tells if the event mask and filters let an event through, data is
+// the event on the wire
+func (f *EventFilter) Wants(privilege []string, data []byte) bool {
+	switch mask := strings.ToLower(f.mask); mask {
+	case "", "on":
+	case "off":
+		return false
+	default:
+		reader := &gami.Principal{Read: strings.Split(mask, ",")}
+		if !reader.CanRead(privilege) {
+			return false
+		}
+	}
+
+	included, hasInclude := false, false
+	for _, filter := range f.filters {
+		if filter.exclude {
+			if filter.re.Match(data) {
+				return false
+			}
+			continue
+		}
+		hasInclude = true
+		included = included || filter.re.Match(data)
+	}
+	return !hasInclude || included
+}
@@ -0,0 +1,104 @@
+package gami
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Raise on Fatal when a keepalive Ping is not answered in time
+var ErrPingTimeout = errors.New("Keepalive ping not answered")
+
+// Raise on Fatal when nothing was read for longer than the idle timeout
+var ErrIdleTimeout = errors.New("Connection idle")
+
+// keepalive ping the server and watch the reads until the client is done,
+// a dead connection is closed so poll reports it on Fatal. The ping runs on
+// its own goroutine so the idle timeout is checked while it waits.
+func (client *AMIClient) keepalive() {
+	tick := client.pingInterval
+	if tick <= 0 {
+		tick = client.idleTimeout / 4
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	pinged := make(chan bool, 1)
+	pinging := false
+	for {
+		select {
+		case <-ticker.C:
+		case alive := <-pinged:
+			pinging = false
+			if !alive {
+				client.kill(ErrPingTimeout)
+				return
+			}
+			continue
+		case <-client.done:
+			return
+		}
+
+		if client.idleTimeout > 0 {
+			if idle := time.Since(time.Unix(0, client.lastRead.Load())); idle > client.idleTimeout {
+				client.kill(ErrIdleTimeout)
+				return
+			}
+		}
+		if client.pingInterval > 0 && !pinging {
+			pinging = true
+			go func() {
+				pinged <- client.ping()
+			}()
+		}
+	}
+}
+
+// ping send a Ping and wait for the answer, false when the server did not
+// answer in time. The Ping is written straight away, it doesn't wait for
+// the scheduler nor go through the interceptors, and a Ping that could not
+// be sent doesn't make the connection dead.
+func (client *AMIClient) ping() bool {
+	client.mu.Lock()
+	actionID := client.opPrefix + strconv.Itoa(client.opNumber)
+	client.opNumber += 1
+	client.mu.Unlock()
+
+	resp, err := client.transmit("Ping", Headers{{"ActionID", actionID}}, false)
+	if err != nil {
+		if err != ErrClosed {
+			client.log().Warn("keepalive ping not sent", "error", err)
+		}
+		return true
+	}
+	timeout := time.NewTimer(client.pingTimeout)
+	defer timeout.Stop()
+	select {
+	case <-resp:
+		return true
+	case <-client.done:
+		return true
+	case <-timeout.C:
+		client.forget(actionID)
+		return false
+	}
+}
+
+// kill close a connection found dead, poll reports err on Fatal
+func (client *AMIClient) kill(err error) {
+	client.mu.Lock()
+	if client.dead == nil {
+		client.dead = err
+	}
+	client.mu.Unlock()
+	client.log().Error("connection dead", "error", err)
+	client.conn.Close()
+}
+
+// setTCPKeepAlive enable TCP keepalive on a dialed connection
+func setTCPKeepAlive(conn net.Conn, period time.Duration) {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetKeepAlive(true)
+		tcp.SetKeepAlivePeriod(period)
+	}
+}
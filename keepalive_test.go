@@ -0,0 +1,140 @@
+package gami_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xytis/gami"
+	"github.com/xytis/gami/gamitest"
+)
+
+func TestKeepalive(t *testing.T) {
+	srv := gamitest.NewServer()
+	defer srv.Close()
+
+	ami, err := gami.Connect(srv.Addr, "admin", "secret", gami.WithKeepalive(20*time.Millisecond, 50*time.Millisecond))
+	assert.NoError(t, err)
+	defer ami.Close()
+
+	select {
+	case err := <-ami.Fatal:
+		t.Fatal("healthy connection found dead:", err)
+	case <-time.After(150 * time.Millisecond):
+	}
+	pings := 0
+	for _, action := range srv.Actions() {
+		if action.Name == "Ping" {
+			pings++
+		}
+	}
+	assert.True(t, pings >= 3, pings)
+}
+
+func TestKeepaliveDefaultTimeout(t *testing.T) {
+	srv := gamitest.NewServer()
+	defer srv.Close()
+	srv.Delay("Ping", 10*time.Millisecond)
+
+	// no timeout waits the interval for the Pong
+	ami, err := gami.Connect(srv.Addr, "admin", "secret", gami.WithKeepalive(40*time.Millisecond, 0))
+	assert.NoError(t, err)
+	defer ami.Close()
+
+	select {
+	case err := <-ami.Fatal:
+		t.Fatal("healthy connection found dead:", err)
+	case <-time.After(150 * time.Millisecond):
+	}
+}
+
+func TestKeepaliveTimeout(t *testing.T) {
+	srv := gamitest.NewServer()
+	defer srv.Close()
+
+	ami, err := gami.Connect(srv.Addr, "admin", "secret", gami.WithKeepalive(20*time.Millisecond, 50*time.Millisecond))
+	assert.NoError(t, err)
+	// the server stops answering, as a half-open connection would
+	srv.Handle("Ping", func(*gamitest.Action) []gami.Headers { return nil })
+
+	select {
+	case err := <-ami.Fatal:
+		assert.Equal(t, gami.ErrPingTimeout, err)
+	case <-time.After(time.Second):
+		t.Fatal("dead connection not detected")
+	}
+	_, ok := <-ami.Events
+	assert.False(t, ok)
+}
+
+func TestIdleTimeout(t *testing.T) {
+	srv := gamitest.NewServer()
+	defer srv.Close()
+
+	ami, err := gami.Connect(srv.Addr, "admin", "secret", gami.WithIdleTimeout(50*time.Millisecond))
+	assert.NoError(t, err)
+	for i := 0; i < 4; i++ {
+		time.Sleep(20 * time.Millisecond)
+		srv.Emit(gamitest.Event("PeerStatus"))
+		<-ami.Events
+	}
+
+	select {
+	case err := <-ami.Fatal:
+		assert.Equal(t, gami.ErrIdleTimeout, err)
+	case <-time.After(time.Second):
+		t.Fatal("idle connection not detected")
+	}
+}
+
+func TestKeepaliveBypassesScheduler(t *testing.T) {
+	srv := gamitest.NewServer()
+	defer srv.Close()
+	// Originate is never answered and holds the only in-flight slot
+	srv.Handle("Originate", func(*gamitest.Action) []gami.Headers { return nil })
+
+	ami, err := gami.Connect(srv.Addr, "admin", "secret",
+		gami.WithMaxInFlight(1),
+		gami.WithPrincipal(&gami.Principal{Name: "ops", Write: []string{"originate"}, Deny: []string{"Ping"}}),
+		gami.WithKeepalive(20*time.Millisecond, 50*time.Millisecond))
+	assert.NoError(t, err)
+	defer ami.Close()
+	_, err = ami.AsyncAction("Originate", nil)
+	assert.NoError(t, err)
+
+	_, ok := srv.WaitAction("Ping", time.Second)
+	assert.True(t, ok, "ping held back by the scheduler or the principal")
+	select {
+	case err := <-ami.Fatal:
+		t.Fatal("healthy connection found dead:", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// the server stops answering, as a half-open connection would
+	srv.Handle("Ping", func(*gamitest.Action) []gami.Headers { return nil })
+	select {
+	case err := <-ami.Fatal:
+		assert.Equal(t, gami.ErrPingTimeout, err)
+	case <-time.After(time.Second):
+		t.Fatal("dead connection not detected")
+	}
+}
+
+func TestIdleTimeoutWhilePinging(t *testing.T) {
+	srv := gamitest.NewServer()
+	defer srv.Close()
+	srv.Handle("Ping", func(*gamitest.Action) []gami.Headers { return nil })
+
+	// the ping waits a minute, the idle timeout must not
+	ami, err := gami.Connect(srv.Addr, "admin", "secret",
+		gami.WithKeepalive(20*time.Millisecond, time.Minute),
+		gami.WithIdleTimeout(100*time.Millisecond))
+	assert.NoError(t, err)
+
+	select {
+	case err := <-ami.Fatal:
+		assert.Equal(t, gami.ErrIdleTimeout, err)
+	case <-time.After(time.Second):
+		t.Fatal("idle connection not detected")
+	}
+}
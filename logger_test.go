@@ -0,0 +1,45 @@
+package gami_test
+
+import (
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xytis/gami"
+	"github.com/xytis/gami/gamitest"
+)
+
+func TestLogger(t *testing.T) {
+	srv := gamitest.NewServer()
+	defer srv.Close()
+	srv.AddUser("admin", "secret")
+
+	var out syncBuffer
+	logger := slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	_, err := gami.Connect(srv.Addr, "admin", "wrong", gami.WithLogger(logger))
+	assert.Error(t, err)
+	ami, err := gami.Connect(srv.Addr, "admin", "secret", gami.WithLogger(logger))
+	assert.NoError(t, err)
+	_, err = ami.Action("Ping", gami.Params{"ActionID": "ping-1"})
+	assert.NoError(t, err)
+	srv.DropConnections()
+	<-ami.Fatal
+	time.Sleep(10 * time.Millisecond)
+
+	log := out.String()
+	for _, expected := range []string{
+		`msg=connected server=` + srv.Addr,
+		`msg="login rejected" server=` + srv.Addr,
+		`user=admin message="Authentication failed"`,
+		`msg="logged in"`,
+		`msg="action sent" server=` + srv.Addr,
+		`action=Ping action_id=ping-1`,
+		`msg="connection lost"`,
+	} {
+		assert.True(t, strings.Contains(log, expected), expected)
+	}
+	assert.False(t, strings.Contains(log, "secret\n"))
+}
@@ -0,0 +1,298 @@
+package gami
+
+import (
+	"expvar"
+	"fmt"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics receives the measurements of a client, implementations must be
+// safe for concurrent use and must not block
+type Metrics interface {
+	// ActionSent is called when an action is written
+	ActionSent(action string)
+	// ActionFailed is called when an action can't be written or the server
+	// answers with Response: Error
+	ActionFailed(action string)
+	// ActionTimedOut is called when Action gives up waiting for the response
+	ActionTimedOut(action string)
+	// ActionLatency is the time between writing an action and reading its response
+	ActionLatency(action string, latency time.Duration)
+	// EventReceived is called for every event read
+	EventReceived(event string)
+	// QueueDepth is the number of events and errors read but not yet
+	// consumed from Events and Errors
+	QueueDepth(events, errors int)
+	// Reconnected is called when a client replaces a lost connection
+	Reconnected()
+	// BytesIn and BytesOut count the bytes of the frames read and written
+	BytesIn(n int)
+	BytesOut(n int)
+}
+
+// DefaultLatencyBuckets are the upper bounds in seconds of the latency
+// histogram kept by ExpvarMetrics
+var DefaultLatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// noMetrics is the Metrics of clients created without WithMetrics
+type noMetrics struct{}
+
+func (noMetrics) ActionSent(string)                   {}
+func (noMetrics) ActionFailed(string)                 {}
+func (noMetrics) ActionTimedOut(string)               {}
+func (noMetrics) ActionLatency(string, time.Duration) {}
+func (noMetrics) EventReceived(string)                {}
+func (noMetrics) QueueDepth(int, int)                 {}
+func (noMetrics) Reconnected()                        {}
+func (noMetrics) BytesIn(int)                         {}
+func (noMetrics) BytesOut(int)                        {}
+
+// ExpvarMetrics publishes the metrics of one or more clients with expvar:
+//
+//	{
+//	  "actions_sent":      {"Originate": 12, ...},
+//	  "actions_failed":    {"Originate": 1, ...},
+//	  "actions_timed_out": {"Originate": 0, ...},
+//	  "action_latency":    {"Originate": {"buckets": {"0.005": 3, ..., "+Inf": 12}, "count": 12, "sum": 0.42}, ...},
+//	  "events":            {"Hangup": 42, ...},
+//	  "pending_events":    0,
+//	  "pending_errors":    0,
+//	  "reconnects":        0,
+//	  "bytes_in":          123456,
+//	  "bytes_out":         4567
+//	}
+//
+// Buckets are cumulative and latencies are in seconds.
+type ExpvarMetrics struct {
+	*expvar.Map
+
+	sent, failed, timedOut, latency, events *expvar.Map
+	pendingEvents, pendingErrors            *expvar.Int
+	reconnects, bytesIn, bytesOut           *expvar.Int
+
+	mu sync.Mutex
+}
+
+// NewExpvarMetrics publish the metrics under name, names must be unique as
+// for expvar.Publish
+func NewExpvarMetrics(name string) *ExpvarMetrics {
+	m := &ExpvarMetrics{
+		Map:           expvar.NewMap(name),
+		sent:          new(expvar.Map),
+		failed:        new(expvar.Map),
+		timedOut:      new(expvar.Map),
+		latency:       new(expvar.Map),
+		events:        new(expvar.Map),
+		pendingEvents: new(expvar.Int),
+		pendingErrors: new(expvar.Int),
+		reconnects:    new(expvar.Int),
+		bytesIn:       new(expvar.Int),
+		bytesOut:      new(expvar.Int),
+	}
+	m.Set("actions_sent", m.sent)
+	m.Set("actions_failed", m.failed)
+	m.Set("actions_timed_out", m.timedOut)
+	m.Set("action_latency", m.latency)
+	m.Set("events", m.events)
+	m.Set("pending_events", m.pendingEvents)
+	m.Set("pending_errors", m.pendingErrors)
+	m.Set("reconnects", m.reconnects)
+	m.Set("bytes_in", m.bytesIn)
+	m.Set("bytes_out", m.bytesOut)
+	return m
+}
+
+// ActionSent implements Metrics
+func (m *ExpvarMetrics) ActionSent(action string) { m.sent.Add(action, 1) }
+
+// ActionFailed implements Metrics
+func (m *ExpvarMetrics) ActionFailed(action string) { m.failed.Add(action, 1) }
+
+// ActionTimedOut implements Metrics
+func (m *ExpvarMetrics) ActionTimedOut(action string) { m.timedOut.Add(action, 1) }
+
+// ActionLatency implements Metrics
+func (m *ExpvarMetrics) ActionLatency(action string, latency time.Duration) {
+	h, ok := m.latency.Get(action).(*histogram)
+	if !ok {
+		m.mu.Lock()
+		if h, ok = m.latency.Get(action).(*histogram); !ok {
+			h = newHistogram(DefaultLatencyBuckets)
+			m.latency.Set(action, h)
+		}
+		m.mu.Unlock()
+	}
+	h.Observe(latency.Seconds())
+}
+
+// EventReceived implements Metrics
+func (m *ExpvarMetrics) EventReceived(event string) { m.events.Add(event, 1) }
+
+// QueueDepth implements Metrics
+func (m *ExpvarMetrics) QueueDepth(events, errors int) {
+	m.pendingEvents.Set(int64(events))
+	m.pendingErrors.Set(int64(errors))
+}
+
+// Reconnected implements Metrics
+func (m *ExpvarMetrics) Reconnected() { m.reconnects.Add(1) }
+
+// BytesIn implements Metrics
+func (m *ExpvarMetrics) BytesIn(n int) { m.bytesIn.Add(int64(n)) }
+
+// BytesOut implements Metrics
+func (m *ExpvarMetrics) BytesOut(n int) { m.bytesOut.Add(int64(n)) }
+
+// histogram is an expvar.Var counting observations in cumulative buckets
+type histogram struct {
+	mu     sync.Mutex
+	bounds []float64
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// String implements expvar.Var
+func (h *histogram) String() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var buf strings.Builder
+	buf.WriteString(`{"buckets": {`)
+	for i, bound := range h.bounds {
+		fmt.Fprintf(&buf, "%q: %d, ", strconv.FormatFloat(bound, 'g', -1, 64), h.counts[i])
+	}
+	fmt.Fprintf(&buf, `"+Inf": %d}, "count": %d, "sum": %s}`, h.count, h.count, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	return buf.String()
+}
+
+// Counter is satisfied by prometheus.Counter
+type Counter interface {
+	Add(float64)
+}
+
+// Gauge is satisfied by prometheus.Gauge
+type Gauge interface {
+	Set(float64)
+}
+
+// Observer is satisfied by prometheus.Histogram and prometheus.Summary
+type Observer interface {
+	Observe(float64)
+}
+
+// Collectors adapts Metrics to Prometheus style collectors without
+// depending on the client library. Labeled metrics are looked up through
+// functions, usually wrapping WithLabelValues of a vector:
+//
+//	actions := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "ami_actions_total"}, []string{"action", "result"})
+//	metrics := &gami.Collectors{
+//		Actions: func(action, result string) gami.Counter { return actions.WithLabelValues(action, result) },
+//	}
+//
+// Nil fields are not collected.
+type Collectors struct {
+	// Actions counts actions by name and result: sent, failed or timeout
+	Actions func(action, result string) Counter
+	// Latency observes response latency in seconds by action name
+	Latency func(action string) Observer
+	// Events counts events by name
+	Events func(event string) Counter
+
+	PendingEvents Gauge
+	PendingErrors Gauge
+	Reconnects    Counter
+	ReadBytes     Counter
+	WrittenBytes  Counter
+}
+
+// Results of an action counted by Collectors.Actions
+const (
+	ResultSent    = "sent"
+	ResultFailed  = "failed"
+	ResultTimeout = "timeout"
+)
+
+// ActionSent implements Metrics
+func (c *Collectors) ActionSent(action string) { c.action(action, ResultSent) }
+
+// ActionFailed implements Metrics
+func (c *Collectors) ActionFailed(action string) { c.action(action, ResultFailed) }
+
+// ActionTimedOut implements Metrics
+func (c *Collectors) ActionTimedOut(action string) { c.action(action, ResultTimeout) }
+
+func (c *Collectors) action(action, result string) {
+	if c.Actions != nil {
+		c.Actions(action, result).Add(1)
+	}
+}
+
+// ActionLatency implements Metrics
+func (c *Collectors) ActionLatency(action string, latency time.Duration) {
+	if c.Latency != nil {
+		c.Latency(action).Observe(latency.Seconds())
+	}
+}
+
+// EventReceived implements Metrics
+func (c *Collectors) EventReceived(event string) {
+	if c.Events != nil {
+		c.Events(event).Add(1)
+	}
+}
+
+// QueueDepth implements Metrics
+func (c *Collectors) QueueDepth(events, errors int) {
+	if c.PendingEvents != nil {
+		c.PendingEvents.Set(float64(events))
+	}
+	if c.PendingErrors != nil {
+		c.PendingErrors.Set(float64(errors))
+	}
+}
+
+// Reconnected implements Metrics
+func (c *Collectors) Reconnected() { add(c.Reconnects, 1) }
+
+// BytesIn implements Metrics
+func (c *Collectors) BytesIn(n int) { add(c.ReadBytes, n) }
+
+// BytesOut implements Metrics
+func (c *Collectors) BytesOut(n int) { add(c.WrittenBytes, n) }
+
+func add(counter Counter, n int) {
+	if counter != nil {
+		counter.Add(float64(n))
+	}
+}
+
+// frameSize is the size of a frame read on the wire
+func frameSize(frame textproto.MIMEHeader) int {
+	n := 2
+	for k, values := range frame {
+		for _, v := range values {
+			n += len(k) + len(v) + 4
+		}
+	}
+	return n
+}
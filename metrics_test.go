@@ -0,0 +1,108 @@
+package gami_test
+
+import (
+	"encoding/json"
+	"expvar"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xytis/gami"
+	"github.com/xytis/gami/gamitest"
+)
+
+// expvarRuns makes the expvar names unique when the tests run several times
+var expvarRuns atomic.Int64
+
+func TestExpvarMetrics(t *testing.T) {
+	srv := gamitest.NewServer()
+	defer srv.Close()
+
+	name := t.Name() + "_" + strconv.FormatInt(expvarRuns.Add(1), 10)
+	ami, err := gami.Connect(srv.Addr, "admin", "secret", gami.WithMetrics(gami.NewExpvarMetrics(name)))
+	assert.NoError(t, err)
+	_, err = ami.Action("Ping", nil)
+	assert.NoError(t, err)
+	_, err = ami.Action("Bogus", nil)
+	assert.NoError(t, err)
+	_, err = ami.Action("Bad\r\nName", nil)
+	assert.Error(t, err)
+	srv.Emit(gamitest.Event("Hangup", "Channel", "SIP/100-00000001"))
+	<-ami.Events
+
+	var vars struct {
+		ActionsSent   map[string]int64 `json:"actions_sent"`
+		ActionsFailed map[string]int64 `json:"actions_failed"`
+		ActionLatency map[string]struct {
+			Buckets map[string]uint64
+			Count   uint64
+		} `json:"action_latency"`
+		Events        map[string]int64 `json:"events"`
+		PendingEvents int64            `json:"pending_events"`
+		BytesIn       int64            `json:"bytes_in"`
+		BytesOut      int64            `json:"bytes_out"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(expvar.Get(name).String()), &vars))
+	assert.Equal(t, map[string]int64{"Login": 1, "Ping": 1, "Bogus": 1}, vars.ActionsSent)
+	assert.Equal(t, map[string]int64{"Bogus": 1, "Bad\r\nName": 1}, vars.ActionsFailed)
+	assert.Equal(t, uint64(1), vars.ActionLatency["Ping"].Count)
+	assert.Equal(t, uint64(1), vars.ActionLatency["Ping"].Buckets["+Inf"])
+	assert.Equal(t, map[string]int64{"Hangup": 1}, vars.Events)
+	assert.Equal(t, int64(0), vars.PendingEvents)
+	assert.True(t, vars.BytesIn > 0)
+	assert.True(t, vars.BytesOut > 0)
+}
+
+type counter struct {
+	mu     *sync.Mutex
+	values map[string]float64
+	name   string
+}
+
+func (c *counter) Add(v float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[c.name] += v
+}
+
+func (c *counter) Set(v float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[c.name] = v
+}
+
+func (c *counter) Observe(v float64) {
+	c.Add(1)
+}
+
+func (c *counter) get(name string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[name]
+}
+
+func TestCollectors(t *testing.T) {
+	srv := gamitest.NewServer()
+	defer srv.Close()
+	srv.Delay("Ping", 50*time.Millisecond)
+
+	values := &counter{mu: new(sync.Mutex), values: make(map[string]float64)}
+	label := func(name string) *counter { return &counter{mu: values.mu, values: values.values, name: name} }
+	metrics := &gami.Collectors{
+		Actions: func(action, result string) gami.Counter { return label(action + "/" + result) },
+		Latency: func(action string) gami.Observer { return label("latency/" + action) },
+	}
+
+	ami, err := gami.Connect(srv.Addr, "admin", "secret", gami.WithMetrics(metrics))
+	assert.NoError(t, err)
+	_, err = ami.Action("Ping", nil)
+	assert.NoError(t, err)
+
+	assert.Equal(t, float64(1), values.get("Login/sent"))
+	assert.Equal(t, float64(1), values.get("Ping/sent"))
+	assert.Equal(t, float64(1), values.get("latency/Ping"))
+	assert.Equal(t, float64(0), values.get("Ping/failed"))
+}
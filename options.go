@@ -0,0 +1,163 @@
+package gami
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// Option configure the client on Connect
+type Option func(*AMIClient)
+
+// WithWriteTimeout set the deadline for writing an action on the connection,
+// zero means no deadline
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(client *AMIClient) {
+		client.writeTimeout = timeout
+	}
+}
+
+// WithBatching coalesce up to max queued actions in a single write, useful
+// when many goroutines send actions at a high rate
+func WithBatching(max int) Option {
+	return func(client *AMIClient) {
+		client.batchSize = max
+	}
+}
+
+// WithDialer replace net.Dial for opening the connection on Connect, e.g.
+// to go through a proxy or to set socket options
+func WithDialer(dial func(network, address string) (net.Conn, error)) Option {
+	return func(client *AMIClient) {
+		client.dial = dial
+	}
+}
+
+// WithRecorder record every frame read and written by the client, see Record
+// for the format and NewReplayer to play it back
+func WithRecorder(w io.Writer) Option {
+	return func(client *AMIClient) {
+		client.recorder = NewRecorder(w)
+	}
+}
+
+// WithTracer call tracer with every frame read and written by the client,
+// tracer must not block
+func WithTracer(tracer func(Trace)) Option {
+	return func(client *AMIClient) {
+		client.tracer = tracer
+	}
+}
+
+// WithTraceWriter write every frame read and written by the client to w, in
+// the format of Trace.String
+func WithTraceWriter(w io.Writer) Option {
+	return WithTracer(traceWriter(w))
+}
+
+// WithLogger log the connection lifecycle, login, action failures and
+// decode errors, nothing is logged by default
+func WithLogger(logger *slog.Logger) Option {
+	return func(client *AMIClient) {
+		client.logger = logger
+	}
+}
+
+// WithMetrics report action, event, queue and traffic measurements to
+// metrics, see ExpvarMetrics and Collectors
+func WithMetrics(metrics Metrics) Option {
+	return func(client *AMIClient) {
+		client.metrics = metrics
+	}
+}
+
+// WithActionInterceptors run interceptors around every action, in order,
+// the first one is the outermost
+func WithActionInterceptors(interceptors ...ActionInterceptor) Option {
+	return func(client *AMIClient) {
+		client.actionInterceptors = append(client.actionInterceptors, interceptors...)
+	}
+}
+
+// WithEventInterceptors run interceptors on every event before it is
+// delivered to Events, in order, the first one is the outermost
+func WithEventInterceptors(interceptors ...EventInterceptor) Option {
+	return func(client *AMIClient) {
+		client.eventInterceptors = append(client.eventInterceptors, interceptors...)
+	}
+}
+
+// WithRateLimit send at most perSecond actions named action, with bursts of
+// up to burst actions. Actions over the limit wait in AsyncAction. A
+// perSecond of 0 or less blocks the action, AsyncAction fails with
+// ErrActionBlocked.
+func WithRateLimit(action string, perSecond float64, burst int) Option {
+	return func(client *AMIClient) {
+		if burst < 1 {
+			burst = 1
+		}
+		client.scheduling().limits[actionKey(action)] = &bucket{rate: perSecond, burst: float64(burst), tokens: float64(burst)}
+	}
+}
+
+// WithMaxInFlight limit the number of actions sent and waiting for their
+// response, further actions wait in AsyncAction
+func WithMaxInFlight(max int) Option {
+	return func(client *AMIClient) {
+		client.scheduling().max = max
+	}
+}
+
+// WithPriority set the priority class of actions, when actions are held
+// back by WithRateLimit or WithMaxInFlight the higher classes are sent first
+func WithPriority(priority Priority, actions ...string) Option {
+	return func(client *AMIClient) {
+		for _, action := range actions {
+			client.scheduling().priority[actionKey(action)] = priority
+		}
+	}
+}
+
+// WithKeepalive send a Ping every interval and treat the connection as dead
+// when it is not answered within timeout, the connection is then closed and
+// ErrPingTimeout sent on Fatal, a timeout of 0 or less waits interval. TCP
+// keepalive is enabled on the socket dialed by Connect with the same
+// interval.
+func WithKeepalive(interval, timeout time.Duration) Option {
+	return func(client *AMIClient) {
+		if timeout <= 0 {
+			timeout = interval
+		}
+		client.pingInterval = interval
+		client.pingTimeout = timeout
+	}
+}
+
+// WithIdleTimeout treat the connection as dead when nothing is read for
+// longer than timeout, the connection is then closed and ErrIdleTimeout sent
+// on Fatal. Combine it with WithKeepalive on servers without much traffic.
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(client *AMIClient) {
+		client.idleTimeout = timeout
+	}
+}
+
+// WithStateObserver call observer with every state transition of the
+// client, observer must not block
+func WithStateObserver(observer func(Transition)) Option {
+	return func(client *AMIClient) {
+		client.stateObserver = observer
+	}
+}
+
+// WithPrincipal limit the actions and events of the client to the
+// privileges of the principal, checked before the other interceptors and
+// again on the action they pass on
+func WithPrincipal(principal *Principal) Option {
+	return func(client *AMIClient) {
+		client.principals = append(client.principals, principal)
+		client.actionInterceptors = append([]ActionInterceptor{principal.ActionInterceptor()}, client.actionInterceptors...)
+		client.eventInterceptors = append([]EventInterceptor{principal.EventInterceptor()}, client.eventInterceptors...)
+	}
+}
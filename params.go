@@ -0,0 +1,128 @@
+package gami
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrInvalidHeader raised when an action header can not be written safely,
+// the error returned is a *HeaderError wrapping it
+var ErrInvalidHeader = errors.New("Invalid action header")
+
+// HeaderError tells which header of an action was rejected and why
+type HeaderError struct {
+	Key    string
+	Value  string
+	Reason string
+}
+
+func (e *HeaderError) Error() string {
+	return fmt.Sprintf("%s %q: %s", ErrInvalidHeader, e.Key, e.Reason)
+}
+
+// Unwrap allows errors.Is(err, ErrInvalidHeader)
+func (e *HeaderError) Unwrap() error {
+	return ErrInvalidHeader
+}
+
+// ActionParams is anything that can be sent as the parameters of an action,
+// Params for the simple cases and Headers when order or repeated keys matter
+type ActionParams interface {
+	Headers() Headers
+}
+
+// Header is a single "Key: Value" line of an action
+type Header struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Headers ordered and repeatable parameters for the actions, written on the
+// wire in the order they were added, e.g.
+//
+//	h := gami.Headers{}
+//	h.Add("Channel", "SIP/100")
+//	h.Add("Variable", "A=1")
+//	h.Add("Variable", "B=2")
+type Headers []Header
+
+// Add append the key and value, keeping any previous value of the key
+func (h *Headers) Add(key, value string) {
+	*h = append(*h, Header{key, value})
+}
+
+// Set replace all the values of the key with value
+func (h *Headers) Set(key, value string) {
+	h.Del(key)
+	h.Add(key, value)
+}
+
+// Del remove all the values of the key
+func (h *Headers) Del(key string) {
+	kept := (*h)[:0]
+	for _, header := range *h {
+		if !strings.EqualFold(header.Key, key) {
+			kept = append(kept, header)
+		}
+	}
+	*h = kept
+}
+
+// Get return the first value of the key, keys are case insensitive as in AMI
+func (h Headers) Get(key string) string {
+	for _, header := range h {
+		if strings.EqualFold(header.Key, key) {
+			return header.Value
+		}
+	}
+	return ""
+}
+
+// Values return all the values of the key in order
+func (h Headers) Values(key string) []string {
+	var values []string
+	for _, header := range h {
+		if strings.EqualFold(header.Key, key) {
+			values = append(values, header.Value)
+		}
+	}
+	return values
+}
+
+// Headers implements ActionParams
+func (h Headers) Headers() Headers {
+	return h
+}
+
+// Headers implements ActionParams, keys are sorted for a deterministic output
+func (p Params) Headers() Headers {
+	keys := make([]string, 0, len(p))
+	for k := range p {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	headers := make(Headers, 0, len(p))
+	for _, k := range keys {
+		headers = append(headers, Header{k, p[k]})
+	}
+	return headers
+}
+
+// checkHeader reject keys and values that would end the header line early
+// and let the caller inject headers or whole actions in the session
+func checkHeader(key, value string) error {
+	if key == "" {
+		return &HeaderError{key, value, "empty key"}
+	}
+	for i := 0; i < len(key); i++ {
+		if c := key[i]; c <= ' ' || c >= 0x7f || c == ':' {
+			return &HeaderError{key, value, fmt.Sprintf("invalid character %q in key", c)}
+		}
+	}
+	if i := strings.IndexAny(value, "\r\n\x00"); i >= 0 {
+		return &HeaderError{key, value, fmt.Sprintf("invalid character %q in value", value[i])}
+	}
+	return nil
+}
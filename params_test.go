@@ -0,0 +1,98 @@
+package gami
+
+import (
+	"fmt"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type RecordMIMEConn struct {
+	MockMIMEConn
+	lines []string
+}
+
+// PrintfLine record the lines that reach the wire
+func (mock *RecordMIMEConn) PrintfLine(format string, args ...interface{}) error {
+	mock.lines = append(mock.lines, strings.Split(fmt.Sprintf(format, args...), "\r\n")...)
+	return nil
+}
+
+func newRecordClient() (*AMIClient, *RecordMIMEConn) {
+	mock := &RecordMIMEConn{}
+	client := &AMIClient{
+		conn:     mock,
+		opPrefix: "r",
+		raw:      make(chan textproto.MIMEHeader),
+		response: make(map[string]chan *AMIResponse),
+	}
+	return client, mock
+}
+
+func TestHeaders(t *testing.T) {
+	h := Headers{}
+	h.Add("Variable", "A=1")
+	h.Add("Channel", "SIP/100")
+	h.Add("variable", "B=2")
+
+	assert.Equal(t, "A=1", h.Get("VARIABLE"))
+	assert.Equal(t, []string{"A=1", "B=2"}, h.Values("Variable"))
+
+	h.Set("Variable", "C=3")
+	assert.Equal(t, Headers{{"Channel", "SIP/100"}, {"Variable", "C=3"}}, h)
+
+	h.Del("channel")
+	assert.Equal(t, Headers{{"Variable", "C=3"}}, h)
+}
+
+func TestAsyncActionHeaders(t *testing.T) {
+	client, mock := newRecordClient()
+
+	h := Headers{}
+	h.Add("Channel", "SIP/100")
+	h.Add("Variable", "A=1")
+	h.Add("Variable", "B=2")
+	_, err := client.AsyncAction("Originate", h)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"Action: Originate",
+		"Channel: SIP/100",
+		"Variable: A=1",
+		"Variable: B=2",
+		"ActionID: r0",
+		"",
+	}, mock.lines)
+}
+
+func TestAsyncActionParamsOrder(t *testing.T) {
+	client, mock := newRecordClient()
+
+	params := Params{"Exten": "100", "Context": "default", "Channel": "SIP/100", "ActionID": "x"}
+	_, err := client.AsyncAction("Originate", params)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"Action: Originate",
+		"ActionID: x",
+		"Channel: SIP/100",
+		"Context: default",
+		"Exten: 100",
+		"",
+	}, mock.lines)
+}
+
+func TestAsyncActionID(t *testing.T) {
+	client, mock := newRecordClient()
+
+	params := Params{}
+	_, err := client.AsyncAction("Ping", params)
+	assert.NoError(t, err)
+	assert.Equal(t, "r0", params["ActionID"])
+
+	_, err = client.AsyncAction("Ping", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "ActionID: r1", mock.lines[len(mock.lines)-2])
+}
@@ -0,0 +1,298 @@
+package gami
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+)
+
+// Raise when a Pool has no server with the name
+var ErrUnknownServer = errors.New("Unknown server")
+
+// Raise when a Pool has not seen the channel on any server
+var ErrUnknownChannel = errors.New("Unknown channel")
+
+// ServerError is an error of one server of a Pool
+type ServerError struct {
+	Server string
+	Err    error
+}
+
+func (e *ServerError) Error() string {
+	return e.Server + ": " + e.Err.Error()
+}
+
+func (e *ServerError) Unwrap() error {
+	return e.Err
+}
+
+// PoolServer is a server to connect in a Pool
+type PoolServer struct {
+	Name    string
+	Address string
+	User    string
+	Secret  string
+	// Options of this server, after the options of the pool
+	Options []Option
+}
+
+// BroadcastResult is the answer of one server to a broadcast action
+type BroadcastResult struct {
+	Server   string
+	Response *AMIResponse
+	Err      error
+}
+
+// Pool manages clients to several servers. Events of every server are
+// merged on Events with EventMeta.Server set to the name of the server,
+// errors are wrapped in ServerError on Errors and Fatal, which must be read
+// as for a client. The channels seen in events are mapped to their server
+// to route ChannelAction.
+type Pool struct {
+	Events chan *AMIEvent
+	Errors chan error
+	Fatal  chan error
+
+	mu       sync.RWMutex
+	clients  map[string]*AMIClient
+	channels map[string]string
+
+	wg   sync.WaitGroup
+	done chan struct{}
+	once sync.Once
+}
+
+// NewPool create an empty pool, see Add
+func NewPool() *Pool {
+	return &Pool{
+		Events:   make(chan *AMIEvent),
+		Errors:   make(chan error),
+		Fatal:    make(chan error),
+		clients:  make(map[string]*AMIClient),
+		channels: make(map[string]string),
+		done:     make(chan struct{}),
+	}
+}
+
+// ConnectPool connect to every server, the servers that failed are left
+// out of the pool and their errors returned as ServerError
+func ConnectPool(servers []PoolServer, options ...Option) (*Pool, error) {
+	pool := NewPool()
+	results := make([]error, len(servers))
+	clients := make([]*AMIClient, len(servers))
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		wg.Add(1)
+		go func(i int, server PoolServer) {
+			defer wg.Done()
+			opts := append(append([]Option(nil), options...), server.Options...)
+			clients[i], results[i] = Connect(server.Address, server.User, server.Secret, opts...)
+		}(i, server)
+	}
+	wg.Wait()
+
+	var errs []error
+	for i, server := range servers {
+		if results[i] == nil {
+			results[i] = pool.Add(server.Name, clients[i])
+		}
+		if results[i] != nil {
+			if clients[i] != nil {
+				clients[i].Shutdown(context.Background())
+			}
+			errs = append(errs, &ServerError{server.Name, results[i]})
+		}
+	}
+	return pool, errors.Join(errs...)
+}
+
+// Add a connected client to the pool under name, names must be unique
+func (pool *Pool) Add(name string, client *AMIClient) error {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if _, ok := pool.clients[name]; ok {
+		return errors.New("Server already in pool: " + name)
+	}
+	pool.clients[name] = client
+	pool.wg.Add(1)
+	go pool.forward(name, client)
+	return nil
+}
+
+// forward the events and errors of a client until it's closed
+func (pool *Pool) forward(name string, client *AMIClient) {
+	defer pool.wg.Done()
+	defer pool.remove(name, client)
+	errs := client.Errors
+	for {
+		select {
+		case ev, ok := <-client.Events:
+			if !ok {
+				return
+			}
+			ev.Server = name
+			pool.learn(name, ev)
+			select {
+			case pool.Events <- ev:
+			case <-pool.done:
+				return
+			}
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			select {
+			case pool.Errors <- &ServerError{name, err}:
+			case <-pool.done:
+				return
+			}
+		case err := <-client.Fatal:
+			select {
+			case pool.Fatal <- &ServerError{name, err}:
+			case <-pool.done:
+				return
+			}
+		case <-pool.done:
+			return
+		}
+	}
+}
+
+// remove a closed client and the channels seen on it
+func (pool *Pool) remove(name string, client *AMIClient) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if pool.clients[name] != client {
+		return
+	}
+	delete(pool.clients, name)
+	for channel, server := range pool.channels {
+		if server == name {
+			delete(pool.channels, channel)
+		}
+	}
+}
+
+// learn the server of the channel of an event
+func (pool *Pool) learn(name string, ev *AMIEvent) {
+	channel := ev.Params["Channel"]
+	if channel == "" {
+		return
+	}
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if ev.ID == "Hangup" {
+		delete(pool.channels, channel)
+	} else {
+		pool.channels[channel] = name
+	}
+}
+
+// Client of the server with the name
+func (pool *Pool) Client(name string) (*AMIClient, bool) {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+	client, ok := pool.clients[name]
+	return client, ok
+}
+
+// Servers return the names of the servers in the pool, sorted
+func (pool *Pool) Servers() []string {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+	names := make([]string, 0, len(pool.clients))
+	for name := range pool.clients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ServerOf return the server a channel was last seen on
+func (pool *Pool) ServerOf(channel string) (string, bool) {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+	server, ok := pool.channels[channel]
+	return server, ok
+}
+
+// Action send an action to the server with the name
+func (pool *Pool) Action(server string, action string, params ActionParams) (*AMIResponse, error) {
+	client, ok := pool.Client(server)
+	if !ok {
+		return nil, &ServerError{server, ErrUnknownServer}
+	}
+	return client.Action(action, params)
+}
+
+// ChannelAction send an action to the server the channel is on, e.g. a
+// Hangup or Redirect
+func (pool *Pool) ChannelAction(channel string, action string, params ActionParams) (*AMIResponse, error) {
+	server, ok := pool.ServerOf(channel)
+	if !ok {
+		return nil, ErrUnknownChannel
+	}
+	return pool.Action(server, action, params)
+}
+
+// Broadcast send an action to every server, e.g. Reload, the results are
+// sorted by server name. Every server gets its own ActionID.
+func (pool *Pool) Broadcast(action string, params ActionParams) []BroadcastResult {
+	var headers Headers
+	if params != nil {
+		headers = append(headers, params.Headers()...)
+		headers.Del("ActionID")
+	}
+	names := pool.Servers()
+	results := make([]BroadcastResult, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			response, err := pool.Action(name, action, append(Headers(nil), headers...))
+			results[i] = BroadcastResult{Server: name, Response: response, Err: err}
+		}(i, name)
+	}
+	wg.Wait()
+	return results
+}
+
+// Shutdown every client of the pool then close Events and Errors, the
+// first error is returned
+func (pool *Pool) Shutdown(ctx context.Context) error {
+	pool.mu.RLock()
+	clients := make(map[string]*AMIClient, len(pool.clients))
+	for name, client := range pool.clients {
+		clients[name] = client
+	}
+	pool.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var first error
+	for name, client := range clients {
+		wg.Add(1)
+		go func(name string, client *AMIClient) {
+			defer wg.Done()
+			if err := client.Shutdown(ctx); err != nil {
+				mu.Lock()
+				if first == nil {
+					first = &ServerError{name, err}
+				}
+				mu.Unlock()
+			}
+		}(name, client)
+	}
+	wg.Wait()
+
+	pool.once.Do(func() {
+		close(pool.done)
+		pool.wg.Wait()
+		close(pool.Events)
+		close(pool.Errors)
+	})
+	return first
+}
@@ -0,0 +1,94 @@
+package gami_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xytis/gami"
+	"github.com/xytis/gami/gamitest"
+)
+
+func TestPool(t *testing.T) {
+	pbx1, pbx2 := gamitest.NewServer(), gamitest.NewServer()
+	defer pbx1.Close()
+	defer pbx2.Close()
+	pbx2.AddUser("admin", "other")
+	pbx1.Respond("Reload", gamitest.Response("Success", "Message", "Module Reloaded"))
+	pbx2.Respond("Reload", gamitest.Response("Error", "Message", "No such module"))
+
+	pool, err := gami.ConnectPool([]gami.PoolServer{
+		{Name: "pbx1", Address: pbx1.Addr, User: "admin", Secret: "secret"},
+		{Name: "pbx2", Address: pbx2.Addr, User: "admin", Secret: "other"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"pbx1", "pbx2"}, pool.Servers())
+
+	pbx2.Emit(gamitest.Event("Newchannel", "Channel", "SIP/200-00000001"))
+	ev := <-pool.Events
+	assert.Equal(t, "Newchannel", ev.ID)
+	assert.Equal(t, "pbx2", ev.Server)
+	server, ok := pool.ServerOf("SIP/200-00000001")
+	assert.True(t, ok)
+	assert.Equal(t, "pbx2", server)
+
+	_, err = pool.ChannelAction("SIP/200-00000001", "Hangup", gami.Params{"Channel": "SIP/200-00000001"})
+	assert.NoError(t, err)
+	hangup, ok := pbx2.WaitAction("Hangup", time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, "SIP/200-00000001", hangup.Headers.Get("Channel"))
+	_, ok = pbx1.WaitAction("Hangup", 10*time.Millisecond)
+	assert.False(t, ok)
+
+	pbx2.Emit(gamitest.Event("Hangup", "Channel", "SIP/200-00000001"))
+	<-pool.Events
+	_, err = pool.ChannelAction("SIP/200-00000001", "Hangup", nil)
+	assert.Equal(t, gami.ErrUnknownChannel, err)
+
+	_, err = pool.Action("pbx3", "Ping", nil)
+	assert.True(t, errors.Is(err, gami.ErrUnknownServer))
+
+	params := gami.Params{"Module": "chan_sip.so"}
+	results := pool.Broadcast("Reload", params)
+	assert.Equal(t, 2, len(results))
+	assert.Equal(t, "pbx1", results[0].Server)
+	assert.Equal(t, "Success", results[0].Response.Status)
+	assert.Equal(t, "pbx2", results[1].Server)
+	assert.Equal(t, "Error", results[1].Response.Status)
+	reload, _ := pbx1.WaitAction("Reload", time.Second)
+	assert.Equal(t, "chan_sip.so", reload.Headers.Get("Module"))
+
+	assert.NoError(t, pool.Shutdown(context.Background()))
+	_, ok = <-pool.Events
+	assert.False(t, ok)
+}
+
+func TestPoolFailures(t *testing.T) {
+	pbx1 := gamitest.NewServer()
+	defer pbx1.Close()
+	pbx2 := gamitest.NewServer()
+	pbx2.AddUser("admin", "other")
+
+	pool, err := gami.ConnectPool([]gami.PoolServer{
+		{Name: "pbx1", Address: pbx1.Addr, User: "admin", Secret: "secret"},
+		{Name: "pbx2", Address: pbx2.Addr, User: "admin", Secret: "wrong"},
+	})
+	var serverErr *gami.ServerError
+	assert.True(t, errors.As(err, &serverErr))
+	assert.Equal(t, "pbx2", serverErr.Server)
+	assert.Equal(t, []string{"pbx1"}, pool.Servers())
+	pbx2.Close()
+
+	pbx1.DropConnections()
+	err = <-pool.Fatal
+	assert.True(t, errors.As(err, &serverErr))
+	assert.Equal(t, "pbx1", serverErr.Server)
+	deadline := time.Now().Add(time.Second)
+	for len(pool.Servers()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 0, len(pool.Servers()))
+	assert.NoError(t, pool.Shutdown(context.Background()))
+}
@@ -0,0 +1,221 @@
+package gami
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Privilege classes of manager.conf, used in the read and write settings
+// of a user and in the Privilege header of the events
+const (
+	ClassSystem    = "system"
+	ClassCall      = "call"
+	ClassLog       = "log"
+	ClassVerbose   = "verbose"
+	ClassCommand   = "command"
+	ClassAgent     = "agent"
+	ClassUser      = "user"
+	ClassConfig    = "config"
+	ClassDTMF      = "dtmf"
+	ClassReporting = "reporting"
+	ClassCDR       = "cdr"
+	ClassDialplan  = "dialplan"
+	ClassOriginate = "originate"
+	ClassAOC       = "aoc"
+	ClassSecurity  = "security"
+	ClassAll       = "all"
+)
+
+// ErrPermissionDenied raised when a Principal may not send an action, the
+// error returned is a *PermissionError wrapping it
+var ErrPermissionDenied = errors.New("Permission denied")
+
+// PermissionError tells which action of a principal was refused and why
+type PermissionError struct {
+	Principal string
+	Action    string
+	Reason    string
+}
+
+func (e *PermissionError) Error() string {
+	return fmt.Sprintf("%s: %s %q: %s", ErrPermissionDenied, e.Principal, e.Action, e.Reason)
+}
+
+// Unwrap allows errors.Is(err, ErrPermissionDenied)
+func (e *PermissionError) Unwrap() error {
+	return ErrPermissionDenied
+}
+
+// privilegeLock guards actionPrivileges, read by the principals of every
+// client
+var privilegeLock sync.RWMutex
+
+// actionPrivileges are the write classes required by the actions, keys are
+// lower case. As in Asterisk one class in common with the principal is
+// enough and an empty list lets anyone send the action.
+var actionPrivileges = map[string][]string{
+	"login":              {},
+	"logoff":             {},
+	"challenge":          {},
+	"ping":               {},
+	"events":             {},
+	"waitevent":          {},
+	"listcommands":       {},
+	"queues":             {},
+	"queuestatus":        {},
+	"queuesummary":       {},
+	"filter":             {ClassSystem},
+	"corestatus":         {ClassSystem, ClassReporting},
+	"coresettings":       {ClassSystem, ClassReporting},
+	"coreshowchannels":   {ClassSystem, ClassReporting},
+	"status":             {ClassSystem, ClassCall, ClassReporting},
+	"hangup":             {ClassSystem, ClassCall},
+	"redirect":           {ClassSystem, ClassCall},
+	"absolutetimeout":    {ClassSystem, ClassCall},
+	"mixmonitor":         {ClassSystem, ClassCall},
+	"atxfer":             {ClassCall},
+	"bridge":             {ClassCall},
+	"park":               {ClassCall},
+	"playdtmf":           {ClassCall},
+	"sendtext":           {ClassCall},
+	"setvar":             {ClassCall},
+	"monitor":            {ClassCall},
+	"stopmonitor":        {ClassCall},
+	"getvar":             {ClassCall, ClassReporting},
+	"extensionstate":     {ClassCall, ClassReporting},
+	"mailboxstatus":      {ClassCall, ClassReporting},
+	"mailboxcount":       {ClassCall, ClassReporting},
+	"originate":          {ClassOriginate},
+	"command":            {ClassCommand},
+	"userevent":          {ClassUser},
+	"agents":             {ClassAgent},
+	"agentlogoff":        {ClassAgent},
+	"queueadd":           {ClassAgent},
+	"queueremove":        {ClassAgent},
+	"queuepause":         {ClassAgent},
+	"getconfig":          {ClassSystem, ClassConfig},
+	"getconfigjson":      {ClassSystem, ClassConfig},
+	"updateconfig":       {ClassConfig},
+	"createconfig":       {ClassConfig},
+	"showdialplan":       {ClassConfig, ClassReporting},
+	"reload":             {ClassSystem, ClassConfig},
+	"moduleload":         {ClassSystem},
+	"modulecheck":        {ClassSystem},
+	"dbget":              {ClassSystem, ClassReporting},
+	"dbput":              {ClassSystem},
+	"dbdel":              {ClassSystem},
+	"sippeers":           {ClassSystem, ClassReporting},
+	"sipshowpeer":        {ClassSystem, ClassReporting},
+	"pjsipshowendpoints": {ClassSystem},
+	"pjsipshowendpoint":  {ClassSystem},
+	"aocmessage":         {ClassAOC},
+}
+
+// RegisterActionPrivilege set the write classes required by an action, e.g.
+// of another module, no class lets anyone send it
+func RegisterActionPrivilege(action string, classes ...string) {
+	privilegeLock.Lock()
+	defer privilegeLock.Unlock()
+	actionPrivileges[strings.ToLower(strings.TrimSpace(action))] = append([]string{}, classes...)
+}
+
+// ActionPrivilege return the write classes required by an action, false
+// when the action is unknown
+func ActionPrivilege(action string) ([]string, bool) {
+	privilegeLock.RLock()
+	defer privilegeLock.RUnlock()
+	classes, ok := actionPrivileges[strings.ToLower(strings.TrimSpace(action))]
+	return append([]string{}, classes...), ok
+}
+
+// Principal is a named user of a client with the privileges of a
+// manager.conf user. Read filters the events by their Privilege and Write
+// the actions by the classes of ActionPrivilege. Deny refuses actions
+// whatever the classes, Allow grants actions the classes don't cover, e.g.
+// unknown actions which are refused otherwise.
+type Principal struct {
+	Name  string
+	Read  []string
+	Write []string
+	Allow []string
+	Deny  []string
+}
+
+// CanRead tells if an event with the privilege is delivered to the
+// principal. Events without Privilege, e.g. the items of an event list, are
+// delivered, the answer to an action the principal could send.
+func (p *Principal) CanRead(privilege []string) bool {
+	if len(privilege) == 0 || (len(privilege) == 1 && privilege[0] == "") {
+		return true
+	}
+	return sharesClass(p.Read, privilege)
+}
+
+// CanWrite return a *PermissionError when the principal may not send the
+// action, the action is trimmed as when written
+func (p *Principal) CanWrite(action string) error {
+	action = strings.TrimSpace(action)
+	for _, denied := range p.Deny {
+		if strings.EqualFold(denied, action) {
+			return &PermissionError{p.Name, action, "action denied"}
+		}
+	}
+	for _, allowed := range p.Allow {
+		if strings.EqualFold(allowed, action) {
+			return nil
+		}
+	}
+	classes, ok := ActionPrivilege(action)
+	switch {
+	case ok && len(classes) == 0:
+		return nil
+	case ok && sharesClass(p.Write, classes):
+		return nil
+	case ok:
+		return &PermissionError{p.Name, action, "requires " + strings.Join(classes, ",")}
+	case sharesClass(p.Write, []string{ClassAll}):
+		// unknown actions need the all class
+		return nil
+	}
+	return &PermissionError{p.Name, action, "action not allowed"}
+}
+
+// ActionInterceptor refuse the actions the principal may not send before
+// they are written
+func (p *Principal) ActionInterceptor() ActionInterceptor {
+	return func(req *ActionRequest, next ActionHandler) (<-chan *AMIResponse, error) {
+		if err := p.CanWrite(req.Action); err != nil {
+			return nil, err
+		}
+		return next(req)
+	}
+}
+
+// EventInterceptor drop the events the principal may not read
+func (p *Principal) EventInterceptor() EventInterceptor {
+	return func(event *AMIEvent, next EventHandler) {
+		if p.CanRead(event.Privilege) {
+			next(event)
+		}
+	}
+}
+
+// sharesClass tells if the granted classes have one of the required, "all"
+// granted takes every class. The "all" of the events' Privilege is not a
+// class and matches nothing by itself.
+func sharesClass(granted []string, required []string) bool {
+	for _, g := range granted {
+		g = strings.ToLower(strings.TrimSpace(g))
+		if g == ClassAll {
+			return true
+		}
+		for _, r := range required {
+			if r = strings.ToLower(strings.TrimSpace(r)); r != ClassAll && g == r {
+				return true
+			}
+		}
+	}
+	return false
+}
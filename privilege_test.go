@@ -0,0 +1,135 @@
+package gami_test
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xytis/gami"
+	"github.com/xytis/gami/gamitest"
+)
+
+func TestPrincipal(t *testing.T) {
+	srv := gamitest.NewServer()
+	defer srv.Close()
+	srv.Respond("Status", gamitest.Response("Success"))
+	srv.Respond("DongleSendSMS", gamitest.Response("Success"))
+
+	// contractor tooling watching calls
+	contractor := &gami.Principal{
+		Name:  "contractor",
+		Read:  []string{gami.ClassCall},
+		Write: []string{gami.ClassReporting, gami.ClassCall},
+		Allow: []string{"DongleSendSMS"},
+		Deny:  []string{"Hangup"},
+	}
+	ami, err := gami.Connect(srv.Addr, "admin", "secret", gami.WithPrincipal(contractor))
+	assert.NoError(t, err)
+	defer ami.Close()
+
+	_, err = ami.Action("Status", nil)
+	assert.NoError(t, err)
+	_, err = ami.Action("dongleSendSMS", nil)
+	assert.NoError(t, err)
+
+	for _, action := range []string{"Originate", "Command", "Hangup", "ModuleUnload"} {
+		_, err = ami.Action(action, nil)
+		assert.True(t, errors.Is(err, gami.ErrPermissionDenied), action)
+		var perr *gami.PermissionError
+		assert.True(t, errors.As(err, &perr))
+		assert.Equal(t, "contractor", perr.Principal)
+		assert.Equal(t, action, perr.Action)
+		_, sent := srv.WaitAction(action, 10*time.Millisecond)
+		assert.False(t, sent, action)
+	}
+
+	srv.Emit(gamitest.Event("PeerStatus", "Privilege", "system,all", "Peer", "SIP/100"))
+	srv.Emit(gamitest.Event("Newchannel", "Privilege", "call,all", "Channel", "SIP/100-00000001"))
+	assert.Equal(t, "Newchannel", (<-ami.Events).ID)
+}
+
+func TestPrincipalClasses(t *testing.T) {
+	admin := &gami.Principal{Name: "admin", Read: []string{"all"}, Write: []string{"all"}, Deny: []string{"command"}}
+	assert.True(t, admin.CanRead([]string{"security", "all"}))
+	assert.NoError(t, admin.CanWrite("Originate"))
+	assert.NoError(t, admin.CanWrite("DongleSendSMS"))
+	assert.True(t, errors.Is(admin.CanWrite("Command"), gami.ErrPermissionDenied))
+
+	reporting := &gami.Principal{Name: "reporting", Read: []string{"cdr"}, Write: []string{"reporting"}}
+	assert.True(t, reporting.CanRead([]string{"cdr", "all"}))
+	assert.False(t, reporting.CanRead([]string{"call", "all"}))
+	// list items carry no privilege
+	assert.True(t, reporting.CanRead([]string{""}))
+	assert.NoError(t, reporting.CanWrite("CoreShowChannels"))
+	assert.NoError(t, reporting.CanWrite("Ping"))
+	assert.Equal(t, `Permission denied: reporting "Originate": requires originate`, reporting.CanWrite("Originate").Error())
+}
+
+// privilegeRuns makes the registered actions unique when the tests run several times
+var privilegeRuns atomic.Int64
+
+func TestRegisterActionPrivilege(t *testing.T) {
+	action := "DongleSendUSSD" + strconv.FormatInt(privilegeRuns.Add(1), 10)
+	dongle := &gami.Principal{Name: "dongle", Write: []string{"dongle"}}
+	assert.True(t, errors.Is(dongle.CanWrite(action), gami.ErrPermissionDenied))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			dongle.CanWrite(action)
+		}
+	}()
+	gami.RegisterActionPrivilege(action, "dongle")
+	<-done
+	assert.NoError(t, dongle.CanWrite(strings.ToLower(action)))
+
+	classes, ok := gami.ActionPrivilege(strings.ToUpper(action))
+	assert.True(t, ok)
+	assert.Equal(t, []string{"dongle"}, classes)
+	// the classes returned are a copy
+	classes[0] = "all"
+	classes, _ = gami.ActionPrivilege(action)
+	assert.Equal(t, []string{"dongle"}, classes)
+}
+
+func TestPrincipalActionName(t *testing.T) {
+	srv := gamitest.NewServer()
+	defer srv.Close()
+	srv.Respond("Status", gamitest.Response("Success"))
+
+	operator := &gami.Principal{Name: "operator", Write: []string{gami.ClassAll}, Deny: []string{"Command"}}
+	reporting := &gami.Principal{Name: "reporting", Write: []string{gami.ClassReporting}}
+	// an interceptor after the principal turning Status into Command
+	rename := func(req *gami.ActionRequest, next gami.ActionHandler) (<-chan *gami.AMIResponse, error) {
+		if req.Action == "Status" {
+			req.Action = "Command"
+		}
+		return next(req)
+	}
+	ami, err := gami.Connect(srv.Addr, "admin", "secret", gami.WithPrincipal(operator), gami.WithActionInterceptors(rename))
+	assert.NoError(t, err)
+	defer ami.Close()
+	limited, err := gami.Connect(srv.Addr, "admin", "secret", gami.WithPrincipal(reporting))
+	assert.NoError(t, err)
+	defer limited.Close()
+
+	_, err = ami.Action("Command ", nil)
+	assert.True(t, errors.Is(err, gami.ErrPermissionDenied))
+	_, err = ami.Action("Status", nil)
+	assert.True(t, errors.Is(err, gami.ErrPermissionDenied))
+	_, err = limited.Action("\tOriginate", nil)
+	assert.True(t, errors.Is(err, gami.ErrPermissionDenied))
+	assert.True(t, errors.Is(operator.CanWrite(" command"), gami.ErrPermissionDenied))
+	_, ok := gami.ActionPrivilege("originate ")
+	assert.True(t, ok)
+
+	for _, action := range []string{"Command", "Originate"} {
+		_, sent := srv.WaitAction(action, 10*time.Millisecond)
+		assert.False(t, sent, action)
+	}
+}
@@ -0,0 +1,638 @@
+// Package proxy shares one AMI connection to Asterisk among many AMI
+// clients. Clients speak the standard protocol to the proxy and log in with
+// the users of the proxy, their actions are sent on the upstream connection
+// with a rewritten ActionID so the responses go back to them, in the order
+// of the actions, and the upstream events are fanned out according to the
+// event mask and filters of every client.
+//
+//	ami, err := gami.Connect("127.0.0.1:5038", "admin", "root")
+//	srv := proxy.New(ami, map[string]string{"crm": "secret"})
+//	go srv.Forward(ami.Events)
+//	err = srv.ListenAndServe(":5039")
+//
+// Headers are passed through gami, so the keys of the responses and events
+// sent to the clients are in canonical form, e.g. "Uniqueid", and only the
+// first value of a repeated key is kept.
+package proxy
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"math/big"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xytis/gami"
+	"github.com/xytis/gami/internal/wire"
+)
+
+// DefaultBanner sent to the clients when the upstream version is unknown
+const DefaultBanner = "Asterisk Call Manager/5.0.2"
+
+// actionPrefix starts the ActionIDs sent upstream, followed by the session,
+// the number of the action in the session and the ActionID of the client,
+// which may be empty or repeated
+const actionPrefix = "gami-proxy-"
+
+// Raise when the upstream connection is lost before answering an action
+var ErrUpstreamLost = errors.New("Upstream connection lost")
+
+// Raise when the upstream doesn't answer an action within ActionTimeout
+var ErrUpstreamTimeout = errors.New("Upstream response timed out")
+
+// Upstream is the connection the actions are sent on, a *gami.AMIClient or
+// a *gami.Failover
+type Upstream interface {
+	AsyncAction(action string, params gami.ActionParams) (<-chan *gami.AMIResponse, error)
+}
+
+// Server accepts AMI clients and proxies them to an upstream connection
+type Server struct {
+	// Banner sent on connect, set from the upstream version by New when
+	// available
+	Banner string
+	// Logger of the proxy, nothing is logged when nil
+	Logger *slog.Logger
+	// QueueSize is the number of frames waiting to be written to a client,
+	// a client falling further behind is disconnected
+	QueueSize int
+	// ActionTimeout is how long the upstream response to an action is
+	// waited for, the client then gets an error and the actions after it
+	// their responses. Zero waits forever.
+	ActionTimeout time.Duration
+
+	upstream Upstream
+	users    map[string]string
+
+	mu        sync.Mutex
+	sessions  map[uint64]*session
+	listeners map[net.Listener]bool
+	next      uint64
+	booted    bool
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// New create a proxy to upstream for the users, a map of username to secret
+func New(upstream Upstream, users map[string]string) *Server {
+	srv := &Server{
+		Banner:        DefaultBanner,
+		QueueSize:     1024,
+		ActionTimeout: 30 * time.Second,
+		upstream:      upstream,
+		users:         users,
+		sessions:      make(map[uint64]*session),
+		listeners:     make(map[net.Listener]bool),
+	}
+	if v, ok := upstream.(interface{ Version() string }); ok && v.Version() != "" {
+		srv.Banner = "Asterisk Call Manager/" + v.Version()
+	}
+	return srv
+}
+
+var discard = slog.New(slog.DiscardHandler)
+
+func (srv *Server) log() *slog.Logger {
+	if srv.Logger == nil {
+		return discard
+	}
+	return srv.Logger
+}
+
+// ListenAndServe listen on the TCP address and serve the clients
+func (srv *Server) ListenAndServe(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return srv.Serve(listener)
+}
+
+// Serve accept clients on the listener until Close, it returns nil once
+// closed
+func (srv *Server) Serve(listener net.Listener) error {
+	srv.mu.Lock()
+	if srv.closed {
+		srv.mu.Unlock()
+		listener.Close()
+		return nil
+	}
+	srv.listeners[listener] = true
+	srv.mu.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			srv.mu.Lock()
+			defer srv.mu.Unlock()
+			delete(srv.listeners, listener)
+			if srv.closed {
+				return nil
+			}
+			return err
+		}
+		srv.mu.Lock()
+		if srv.closed {
+			srv.mu.Unlock()
+			conn.Close()
+			continue
+		}
+		srv.next++
+		s := &session{
+			id:      srv.next,
+			srv:     srv,
+			conn:    conn,
+			out:     make(chan outFrame, srv.QueueSize),
+			replies: make(chan reply, srv.QueueSize),
+			done:    make(chan struct{}),
+		}
+		srv.sessions[s.id] = s
+		srv.wg.Add(1)
+		srv.mu.Unlock()
+		go s.serve()
+	}
+}
+
+// Forward publish the events until the channel is closed, e.g. the Events
+// of the upstream client
+func (srv *Server) Forward(events <-chan *gami.AMIEvent) {
+	for ev := range events {
+		srv.Publish(ev)
+	}
+}
+
+// Publish send an upstream event to the clients. Events carrying the
+// ActionID of a client, e.g. the items of an event list, go to that client
+// only.
+func (srv *Server) Publish(ev *gami.AMIEvent) {
+	if id := ev.Params["Actionid"]; strings.HasPrefix(id, actionPrefix) {
+		session, actionID, ok := srv.route(id)
+		if !ok {
+			return
+		}
+		out := *ev
+		out.Params = make(gami.Params, len(ev.Params))
+		for k, v := range ev.Params {
+			out.Params[k] = v
+		}
+		delete(out.Params, "Actionid")
+		if actionID != "" {
+			out.Params["ActionID"] = actionID
+		}
+		session.event(id, out.MarshalAMI())
+		return
+	}
+
+	srv.mu.Lock()
+	switch ev.ID {
+	case "FullyBooted":
+		srv.booted = true
+	case "Shutdown":
+		srv.booted = false
+	}
+	sessions := make([]*session, 0, len(srv.sessions))
+	for _, s := range srv.sessions {
+		sessions = append(sessions, s)
+	}
+	srv.mu.Unlock()
+
+	data := ev.MarshalAMI()
+	for _, s := range sessions {
+		if s.wants(ev.Privilege, data) {
+			s.send(data, false)
+		}
+	}
+}
+
+// route find the session of an upstream ActionID and the ActionID of the
+// client
+func (srv *Server) route(id string) (*session, string, bool) {
+	parts := strings.SplitN(strings.TrimPrefix(id, actionPrefix), "-", 3)
+	if len(parts) != 3 {
+		return nil, "", false
+	}
+	n, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return nil, "", false
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	s, ok := srv.sessions[n]
+	return s, parts[2], ok
+}
+
+// Close stop the listeners and disconnect every client, the upstream
+// connection is left open
+func (srv *Server) Close() error {
+	srv.mu.Lock()
+	srv.closed = true
+	var err error
+	for listener := range srv.listeners {
+		if e := listener.Close(); err == nil {
+			err = e
+		}
+	}
+	sessions := make([]*session, 0, len(srv.sessions))
+	for _, s := range srv.sessions {
+		sessions = append(sessions, s)
+	}
+	srv.mu.Unlock()
+
+	for _, s := range sessions {
+		s.close()
+	}
+	srv.wg.Wait()
+	return err
+}
+
+func (srv *Server) remove(s *session) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	delete(srv.sessions, s.id)
+}
+
+// session is a client connected to the proxy
+type session struct {
+	id      uint64
+	srv     *Server
+	conn    net.Conn
+	out     chan outFrame
+	replies chan reply
+	done    chan struct{}
+	once    sync.Once
+	// actions sent upstream, only used by serve
+	actions uint64
+
+	mu          sync.Mutex
+	user        string
+	loggedIn    bool
+	challenge   string
+	eventFilter wire.EventFilter
+	// pending actions by upstream ActionID with the events held until the
+	// response is sent
+	pending map[string][][]byte
+}
+
+// reply to an action of the client, the replies are sent in the order the
+// actions were received whether the proxy or the upstream answers
+type reply struct {
+	frames [][]byte
+	// last closes the connection once sent, e.g. after Logoff
+	last bool
+	// resp of an action sent upstream with the ActionID id, answered with
+	// the ActionID of the client
+	resp     <-chan *gami.AMIResponse
+	id       string
+	actionID string
+}
+
+type outFrame struct {
+	data []byte
+	// last closes the connection once written, e.g. after Logoff
+	last bool
+}
+
+func (s *session) serve() {
+	defer s.srv.wg.Done()
+	go s.write()
+	s.send([]byte(s.srv.Banner+"\r\n"), false)
+	go s.respond()
+
+	reader := bufio.NewReader(s.conn)
+	for {
+		headers, err := wire.ReadAction(reader)
+		if err != nil {
+			s.close()
+			return
+		}
+		s.handle(headers)
+	}
+}
+
+// write the queued frames to the connection
+func (s *session) write() {
+	for {
+		select {
+		case frame := <-s.out:
+			if _, err := s.conn.Write(frame.data); err != nil || frame.last {
+				s.close()
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// respond send the replies in order, waiting for the upstream responses
+func (s *session) respond() {
+	for {
+		var r reply
+		select {
+		case r = <-s.replies:
+		case <-s.done:
+			return
+		}
+		if r.resp != nil {
+			frame, ok := s.await(r)
+			if !ok {
+				return
+			}
+			r.frames = [][]byte{frame}
+		}
+		for i, frame := range r.frames {
+			s.send(frame, r.last && i == len(r.frames)-1)
+		}
+		if r.id != "" {
+			s.flush(r.id)
+		}
+	}
+}
+
+// await the upstream response of a reply within ActionTimeout, false when
+// the client is gone
+func (s *session) await(r reply) ([]byte, bool) {
+	var timeout <-chan time.Time
+	if s.srv.ActionTimeout > 0 {
+		timer := time.NewTimer(s.srv.ActionTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case resp, ok := <-r.resp:
+		return answer(resp, ok, r.actionID), true
+	case <-timeout:
+		return response(r.actionID, "Error", "Message", ErrUpstreamTimeout.Error()), true
+	case <-s.done:
+		return nil, false
+	}
+}
+
+// flush send the events held until the response of an upstream ActionID
+// was sent, the events arriving meanwhile go after them
+func (s *session) flush(id string) {
+	for {
+		s.mu.Lock()
+		held := s.pending[id]
+		if len(held) == 0 {
+			delete(s.pending, id)
+			s.mu.Unlock()
+			return
+		}
+		s.pending[id] = nil
+		s.mu.Unlock()
+		for _, data := range held {
+			s.send(data, false)
+		}
+	}
+}
+
+// event send an event carrying an upstream ActionID of the client, held
+// until the response is sent as the events of a list must not overtake it
+func (s *session) event(id string, data []byte) {
+	s.mu.Lock()
+	held, ok := s.pending[id]
+	if ok && len(held) < s.srv.QueueSize {
+		s.pending[id] = append(held, data)
+	}
+	s.mu.Unlock()
+	switch {
+	case !ok:
+		s.send(data, false)
+	case len(held) >= s.srv.QueueSize:
+		s.srv.log().Warn("client too slow, disconnected", "session", s.id, "user", s.username())
+		s.close()
+	}
+}
+
+// answer build the frame of an upstream response for the client
+func answer(resp *gami.AMIResponse, ok bool, actionID string) []byte {
+	if !ok || resp == nil {
+		return response(actionID, "Error", "Message", ErrUpstreamLost.Error())
+	}
+	frame := gami.Headers{{Key: "Response", Value: resp.Status}}
+	if actionID != "" {
+		frame.Add("ActionID", actionID)
+	}
+	keys := make([]string, 0, len(resp.Params))
+	for k := range resp.Params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		frame.Add(k, resp.Params[k])
+	}
+	return wire.Marshal(frame)
+}
+
+// queue a reply after the replies to the previous actions
+func (s *session) queue(r reply) {
+	select {
+	case s.replies <- r:
+	case <-s.done:
+	}
+}
+
+// send queue a frame, a client too slow to read its frames is disconnected
+func (s *session) send(data []byte, last bool) {
+	select {
+	case s.out <- outFrame{data, last}:
+	case <-s.done:
+	default:
+		s.srv.log().Warn("client too slow, disconnected", "session", s.id, "user", s.username())
+		s.close()
+	}
+}
+
+func (s *session) close() {
+	s.once.Do(func() {
+		close(s.done)
+		s.conn.Close()
+		s.srv.remove(s)
+	})
+}
+
+func (s *session) username() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.user
+}
+
+// reply answer an action of the client, kv are header keys and values
+func (s *session) reply(actionID string, status string, kv ...string) {
+	s.queue(reply{frames: [][]byte{response(actionID, status, kv...)}})
+}
+
+// response build a response frame, kv are header keys and values
+func response(actionID string, status string, kv ...string) []byte {
+	frame := gami.Headers{{Key: "Response", Value: status}}
+	if actionID != "" {
+		frame.Add("ActionID", actionID)
+	}
+	for i := 0; i+1 < len(kv); i += 2 {
+		frame.Add(kv[i], kv[i+1])
+	}
+	return wire.Marshal(frame)
+}
+
+// handle an action of the client, the session actions are answered by the
+// proxy and the others are sent upstream
+func (s *session) handle(headers gami.Headers) {
+	name := headers.Get("Action")
+	actionID := headers.Get("ActionID")
+	s.mu.Lock()
+	loggedIn := s.loggedIn
+	s.mu.Unlock()
+
+	switch action := strings.ToLower(name); {
+	case action == "":
+		s.reply(actionID, "Error", "Message", "Missing action in request")
+	case action == "challenge":
+		s.challengeMD5(headers, actionID)
+	case action == "login":
+		s.login(headers, actionID)
+	case action == "logoff":
+		s.queue(reply{frames: [][]byte{response(actionID, "Goodbye", "Message", "Thanks for all the fish.")}, last: true})
+	case !loggedIn:
+		s.reply(actionID, "Error", "Message", "Authentication Required")
+	case action == "events":
+		s.events(headers, actionID)
+	case action == "filter":
+		s.filter(headers, actionID)
+	case action == "ping":
+		s.reply(actionID, "Success", "Ping", "Pong", "Timestamp", strconv.FormatInt(time.Now().Unix(), 10)+".000000")
+	default:
+		s.forward(name, headers, actionID)
+	}
+}
+
+func (s *session) challengeMD5(headers gami.Headers, actionID string) {
+	if !strings.EqualFold(headers.Get("AuthType"), "md5") {
+		s.reply(actionID, "Error", "Message", "Must specify AuthType")
+		return
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(1<<31))
+	if err != nil {
+		s.reply(actionID, "Error", "Message", err.Error())
+		return
+	}
+	challenge := n.String()
+	s.mu.Lock()
+	s.challenge = challenge
+	s.mu.Unlock()
+	s.reply(actionID, "Success", "Challenge", challenge)
+}
+
+// login check the user against the users of the proxy, with the Secret or
+// the MD5 Key of a previous Challenge
+func (s *session) login(headers gami.Headers, actionID string) {
+	user := headers.Get("Username")
+	secret, ok := s.srv.users[user]
+	s.mu.Lock()
+	challenge := s.challenge
+	s.mu.Unlock()
+
+	given, expected := headers.Get("Secret"), secret
+	if strings.EqualFold(headers.Get("AuthType"), "md5") {
+		sum := md5.Sum([]byte(challenge + secret))
+		given, expected = headers.Get("Key"), hex.EncodeToString(sum[:])
+		ok = ok && challenge != ""
+	}
+	if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(expected)) != 1 {
+		s.srv.log().Warn("login failed", "session", s.id, "user", user, "remote", s.conn.RemoteAddr().String())
+		s.reply(actionID, "Error", "Message", "Authentication failed")
+		return
+	}
+
+	s.mu.Lock()
+	s.user = user
+	s.loggedIn = true
+	if mask := headers.Get("Events"); mask != "" {
+		s.eventFilter.SetMask(mask)
+	}
+	s.mu.Unlock()
+	s.srv.log().Info("client logged in", "session", s.id, "user", user, "remote", s.conn.RemoteAddr().String())
+	r := reply{frames: [][]byte{response(actionID, "Success", "Message", "Authentication accepted")}}
+
+	s.srv.mu.Lock()
+	booted := s.srv.booted
+	s.srv.mu.Unlock()
+	if booted {
+		booted := &gami.AMIEvent{ID: "FullyBooted", Privilege: []string{"system", "all"}, Params: gami.Params{"Status": "Fully Booted"}}
+		if data := booted.MarshalAMI(); s.wants(booted.Privilege, data) {
+			r.frames = append(r.frames, data)
+		}
+	}
+	s.queue(r)
+}
+
+// events set the event mask of the client, "off", "on" or a list of classes
+func (s *session) events(headers gami.Headers, actionID string) {
+	mask := headers.Get("EventMask")
+	s.mu.Lock()
+	s.eventFilter.SetMask(mask)
+	s.mu.Unlock()
+	if strings.EqualFold(mask, "off") {
+		s.reply(actionID, "Success", "Events", "Off")
+		return
+	}
+	s.reply(actionID, "Success", "Events", "On")
+}
+
+// filter add a regular expression matched against the lines of the events,
+// filters starting with ! drop the events they match
+func (s *session) filter(headers gami.Headers, actionID string) {
+	if op := headers.Get("Operation"); op != "" && !strings.EqualFold(op, "Add") {
+		s.reply(actionID, "Error", "Message", "Unknown operation")
+		return
+	}
+	s.mu.Lock()
+	err := s.eventFilter.AddFilter(headers.Get("Filter"))
+	s.mu.Unlock()
+	if err != nil {
+		s.reply(actionID, "Error", "Message", "Filter Not Added")
+		return
+	}
+	s.reply(actionID, "Success", "Message", "Filter Added Successfully")
+}
+
+// forward send an action upstream, respond sends the response back to the
+// client once the previous actions are answered
+func (s *session) forward(name string, headers gami.Headers, actionID string) {
+	s.actions++
+	id := actionPrefix + strconv.FormatUint(s.id, 10) + "-" + strconv.FormatUint(s.actions, 10) + "-" + actionID
+	upstream := append(gami.Headers(nil), headers...)
+	upstream.Del("Action")
+	upstream.Set("ActionID", id)
+
+	s.mu.Lock()
+	if s.pending == nil {
+		s.pending = make(map[string][][]byte)
+	}
+	s.pending[id] = nil
+	s.mu.Unlock()
+
+	resp, err := s.srv.upstream.AsyncAction(name, upstream)
+	if err != nil {
+		s.queue(reply{frames: [][]byte{response(actionID, "Error", "Message", err.Error())}, id: id})
+		return
+	}
+	s.queue(reply{resp: resp, id: id, actionID: actionID})
+}
+
+// wants tells if the event mask and filters of the client let an event
+// through, data is the event on the wire
+func (s *session) wants(privilege []string, data []byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.loggedIn && s.eventFilter.Wants(privilege, data)
+}
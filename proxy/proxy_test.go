@@ -0,0 +1,316 @@
+package proxy_test
+
+import (
+	"bufio"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xytis/gami"
+	"github.com/xytis/gami/gamitest"
+	"github.com/xytis/gami/proxy"
+)
+
+// start a proxy to a fake Asterisk, the clients log in as crm or ops
+func start(t *testing.T) (*gamitest.Server, *proxy.Server, string) {
+	pbx := gamitest.NewServer()
+	pbx.Boot()
+	upstream, err := gami.Connect(pbx.Addr, "admin", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := proxy.New(upstream, map[string]string{"crm": "crm-secret", "ops": "ops-secret"})
+	go srv.Forward(upstream.Events)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(listener)
+	t.Cleanup(func() {
+		srv.Close()
+		upstream.Close()
+		pbx.Close()
+	})
+	return pbx, srv, listener.Addr().String()
+}
+
+func next(t *testing.T, ami *gami.AMIClient) *gami.AMIEvent {
+	t.Helper()
+	select {
+	case ev := <-ami.Events:
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+		return nil
+	}
+}
+
+func TestProxyLogin(t *testing.T) {
+	_, _, addr := start(t)
+	if _, err := gami.Connect(addr, "crm", "wrong"); err == nil {
+		t.Fatal("login with a wrong secret accepted")
+	}
+	ami, err := gami.Connect(addr, "crm", "crm-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ami.Close()
+	if ami.Version() != "5.0.2" {
+		t.Fatalf("version %q", ami.Version())
+	}
+	// the upstream was booted before the client logged in
+	if ev := next(t, ami); ev.ID != "FullyBooted" {
+		t.Fatalf("got %s, want FullyBooted", ev.ID)
+	}
+}
+
+func TestProxyActions(t *testing.T) {
+	pbx, _, addr := start(t)
+	pbx.Respond("CoreStatus", gamitest.Response("Success", "CoreCurrentCalls", "3"))
+	pbx.Respond("Status", gamitest.EventList([]gami.Headers{
+		gamitest.Event("Status", "Channel", "SIP/100-00000001"),
+	}, "StatusComplete")...)
+
+	crm, err := gami.Connect(addr, "crm", "crm-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer crm.Close()
+	ops, err := gami.Connect(addr, "ops", "ops-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ops.Close()
+	next(t, crm)
+	next(t, ops)
+
+	response, err := crm.Action("CoreStatus", gami.Params{"ActionID": "mine"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.ID != "mine" || response.Params["Corecurrentcalls"] != "3" {
+		t.Fatalf("unexpected response %+v", response)
+	}
+	action, ok := pbx.WaitAction("CoreStatus", time.Second)
+	if !ok {
+		t.Fatal("action not sent upstream")
+	}
+	if id := action.ActionID(); id == "mine" || !strings.HasSuffix(id, "-mine") {
+		t.Fatalf("upstream ActionID %q not rewritten", id)
+	}
+
+	// the events of a list go to the client that asked only
+	response, err = ops.Action("Status", gami.Params{"ActionID": "list"})
+	if err != nil || response.Status != "Success" {
+		t.Fatal(response, err)
+	}
+	if ev := next(t, ops); ev.ID != "Status" || ev.Params["Actionid"] != "list" {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+	if ev := next(t, ops); ev.ID != "StatusComplete" {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+	select {
+	case ev := <-crm.Events:
+		t.Fatalf("list event %s sent to another client", ev.ID)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// Ping is answered by the proxy
+	if response, err = crm.Action("Ping", nil); err != nil || response.Params["Ping"] != "Pong" {
+		t.Fatal(response, err)
+	}
+	if _, ok := pbx.WaitAction("Ping", 10*time.Millisecond); ok {
+		t.Fatal("Ping sent upstream")
+	}
+}
+
+func TestProxyEvents(t *testing.T) {
+	pbx, _, addr := start(t)
+	crm, err := gami.Connect(addr, "crm", "crm-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer crm.Close()
+	ops, err := gami.Connect(addr, "ops", "ops-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ops.Close()
+	next(t, crm)
+	next(t, ops)
+
+	// crm wants calls but not Local channels, ops everything
+	if response, err := crm.Action("Events", gami.Params{"EventMask": "call"}); err != nil || response.Params["Events"] != "On" {
+		t.Fatal(response, err)
+	}
+	if response, err := crm.Action("Filter", gami.Params{"Operation": "Add", "Filter": "!Channel: Local/"}); err != nil || response.Status != "Success" {
+		t.Fatal(response, err)
+	}
+	if _, ok := pbx.WaitAction("Events", 10*time.Millisecond); ok {
+		t.Fatal("Events sent upstream")
+	}
+
+	pbx.Emit(gamitest.Event("PeerStatus", "Privilege", "system,all", "Peer", "SIP/100"))
+	pbx.Emit(gamitest.Event("Newchannel", "Privilege", "call,all", "Channel", "Local/100@default-00000001;1"))
+	pbx.Emit(gamitest.Event("Newchannel", "Privilege", "call,all", "Channel", "SIP/100-00000001"))
+
+	if ev := next(t, crm); ev.Params["Channel"] != "SIP/100-00000001" {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+	for _, want := range []string{"PeerStatus", "Newchannel", "Newchannel"} {
+		if ev := next(t, ops); ev.ID != want {
+			t.Fatalf("got %s, want %s", ev.ID, want)
+		}
+	}
+}
+
+func TestProxyPipelining(t *testing.T) {
+	pbx, _, addr := start(t)
+	pbx.Delay("CoreStatus", 20*time.Millisecond)
+	pbx.Respond("CoreStatus", gamitest.Response("Success", "CoreCurrentCalls", "3"))
+	pbx.Respond("SIPpeers", gamitest.Response("Success", "Message", "Peer status list will follow"))
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// actions without ActionID are answered in order, Ping by the proxy
+	_, err = conn.Write([]byte("Action: Login\r\nUsername: crm\r\nSecret: crm-secret\r\nEvents: off\r\n\r\n" +
+		"Action: CoreStatus\r\n\r\n" +
+		"Action: SIPpeers\r\n\r\n" +
+		"Action: Ping\r\n\r\n" +
+		"Action: CoreStatus\r\n\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := textproto.NewReader(bufio.NewReader(conn))
+	if _, err := reader.ReadLine(); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Authentication accepted", "3", "Peer status list will follow", "Pong", "3"} {
+		frame, err := reader.ReadMIMEHeader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if frame.Get("Response") != "Success" || frame.Get("ActionID") != "" {
+			t.Fatalf("unexpected response %v", frame)
+		}
+		if got := frame.Get("Message") + frame.Get("Corecurrentcalls") + frame.Get("Ping"); got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+
+	// every action has its own upstream ActionID
+	seen := map[string]bool{}
+	for _, action := range pbx.Actions() {
+		if action.Name == "CoreStatus" || action.Name == "SIPpeers" {
+			seen[action.ActionID()] = true
+		}
+	}
+	if len(seen) != 3 {
+		t.Fatalf("upstream ActionIDs not unique: %v", seen)
+	}
+}
+
+// upstream lets a test answer the actions sent through the proxy
+type upstream struct {
+	mu   sync.Mutex
+	sent map[string]chan *gami.AMIResponse
+	ids  map[string]string
+}
+
+func (up *upstream) AsyncAction(action string, params gami.ActionParams) (<-chan *gami.AMIResponse, error) {
+	up.mu.Lock()
+	defer up.mu.Unlock()
+	id := params.Headers().Get("ActionID")
+	up.sent[id] = make(chan *gami.AMIResponse, 1)
+	up.ids[action] = id
+	return up.sent[id], nil
+}
+
+// answer the last action sent with the name once received, returning its
+// ActionID
+func (up *upstream) answer(action string, resp *gami.AMIResponse) string {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		up.mu.Lock()
+		id, ok := up.ids[action]
+		if ok {
+			up.sent[id] <- resp
+		}
+		up.mu.Unlock()
+		if ok {
+			return id
+		}
+	}
+	return ""
+}
+
+func TestProxySlowAction(t *testing.T) {
+	up := &upstream{sent: map[string]chan *gami.AMIResponse{}, ids: map[string]string{}}
+	srv := proxy.New(up, map[string]string{"crm": "crm-secret", "ops": "ops-secret"})
+	srv.ActionTimeout = 100 * time.Millisecond
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(listener)
+	defer srv.Close()
+
+	crm, err := gami.Connect(listener.Addr().String(), "crm", "crm-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer crm.Close()
+	ops, err := gami.Connect(listener.Addr().String(), "ops", "ops-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ops.Close()
+
+	// Originate is never answered, the list behind it is
+	originate, err := crm.AsyncAction("Originate", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := crm.AsyncAction("Status", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := up.answer("Status", &gami.AMIResponse{Status: "Success", Params: gami.Params{"Message": "Channel status will follow"}})
+
+	// the list event of crm doesn't hold the events of ops back
+	published := make(chan struct{})
+	go func() {
+		srv.Publish(&gami.AMIEvent{ID: "Status", Params: gami.Params{"Actionid": id, "Channel": "SIP/100-00000001"}})
+		srv.Publish(&gami.AMIEvent{ID: "PeerStatus", Privilege: []string{"system", "all"}, Params: gami.Params{"Peer": "SIP/100"}})
+		close(published)
+	}()
+	select {
+	case <-published:
+	case <-time.After(50 * time.Millisecond):
+		t.Fatal("Publish blocked by a client waiting for a response")
+	}
+	if ev := next(t, ops); ev.ID != "PeerStatus" {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+
+	// crm gets the timeout, then the list in order
+	if response := <-originate; response == nil || response.Params["Message"] != proxy.ErrUpstreamTimeout.Error() {
+		t.Fatalf("unexpected response %+v", response)
+	}
+	if response := <-status; response == nil || response.Status != "Success" {
+		t.Fatalf("unexpected response %+v", response)
+	}
+	// the list event was held until then
+	for _, want := range []string{"PeerStatus", "Status"} {
+		if ev := next(t, crm); ev.ID != want {
+			t.Fatalf("got %s, want %s", ev.ID, want)
+		}
+	}
+}
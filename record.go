@@ -0,0 +1,215 @@
+package gami
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/textproto"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Directions of a recorded frame
+const (
+	// RecordIn frames received from Asterisk
+	RecordIn = "in"
+	// RecordOut actions sent to Asterisk
+	RecordOut = "out"
+)
+
+// redacted replaces the value of secret headers in recordings and traces
+const redacted = "********"
+
+// Record is one frame of a session recording. A recording is a JSON Lines
+// file with one record per line:
+//
+//	{"time":"2015-01-02T15:04:05.123456789Z","dir":"out","headers":[{"key":"Action","value":"Login"},{"key":"Username","value":"admin"},{"key":"Secret","value":"********"}]}
+//	{"time":"2015-01-02T15:04:05.125Z","dir":"in","headers":[{"key":"Actionid","value":"r0"},{"key":"Message","value":"Authentication accepted"},{"key":"Response","value":"Success"}]}
+//
+// Outgoing headers keep the order they were written, incoming headers are
+// sorted by key. Secret and Key values are redacted.
+type Record struct {
+	Time    time.Time `json:"time"`
+	Dir     string    `json:"dir"`
+	Headers Headers   `json:"headers"`
+}
+
+// Recorder writes a session recording, safe for concurrent use
+type Recorder struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+// NewRecorder write records to w
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{enc: json.NewEncoder(w)}
+}
+
+// Record add a frame, the first write error is kept and returned by Err
+func (rec *Recorder) Record(dir string, headers Headers) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.err != nil {
+		return
+	}
+	rec.err = rec.enc.Encode(Record{Time: time.Now(), Dir: dir, Headers: redact(headers)})
+}
+
+// Err return the first error writing the recording
+func (rec *Recorder) Err() error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.err
+}
+
+// Replayer is a Transport playing the incoming frames of a recording, the
+// actions written to it are discarded
+type Replayer struct {
+	speed float64
+	done  chan struct{}
+	once  sync.Once
+
+	mu      sync.Mutex
+	records []Record
+	last    time.Time
+	start   time.Time
+}
+
+// NewReplayer read a recording made with WithRecorder. Frames are played
+// with their original spacing divided by speed, zero speed plays them
+// without waiting.
+func NewReplayer(r io.Reader, speed float64) (*Replayer, error) {
+	var records []Record
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, err
+		}
+		if record.Dir == RecordIn {
+			records = append(records, record)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &Replayer{records: records, speed: speed, done: make(chan struct{})}, nil
+}
+
+// ReadFrame implements Transport, io.EOF at the end of the recording or
+// once closed
+func (rp *Replayer) ReadFrame() (textproto.MIMEHeader, error) {
+	rp.mu.Lock()
+	if len(rp.records) == 0 {
+		rp.mu.Unlock()
+		return nil, io.EOF
+	}
+	record := rp.records[0]
+	rp.records = rp.records[1:]
+	var wait time.Duration
+	if rp.speed > 0 {
+		if rp.start.IsZero() {
+			rp.start, rp.last = time.Now(), record.Time
+		}
+		wait = time.Until(rp.start.Add(time.Duration(float64(record.Time.Sub(rp.last)) / rp.speed)))
+	}
+	rp.mu.Unlock()
+
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-rp.done:
+			return nil, io.EOF
+		}
+	}
+	if rp.speed > 0 {
+		rp.mu.Lock()
+		rp.start, rp.last = time.Now(), record.Time
+		rp.mu.Unlock()
+	}
+
+	data := textproto.MIMEHeader{}
+	for _, header := range record.Headers {
+		data.Add(header.Key, header.Value)
+	}
+	return data, nil
+}
+
+// WriteFrame implements Transport
+func (rp *Replayer) WriteFrame(frame []byte) error {
+	return nil
+}
+
+// Close implements Transport, a paced ReadFrame returns at once
+func (rp *Replayer) Close() error {
+	rp.once.Do(func() {
+		close(rp.done)
+	})
+	rp.mu.Lock()
+	rp.records = nil
+	rp.mu.Unlock()
+	return nil
+}
+
+// Replay call handler with every event of a recording, without a client
+func Replay(r io.Reader, speed float64, handler func(*AMIEvent)) error {
+	replayer, err := NewReplayer(r, speed)
+	if err != nil {
+		return err
+	}
+	var sequence uint64
+	for {
+		data, err := replayer.ReadFrame()
+		if err == io.EOF {
+			return nil
+		}
+		if data.Get("Event") == "" {
+			continue
+		}
+		event, err := newEvent(&data)
+		if err != nil {
+			return err
+		}
+		sequence++
+		event.Received = time.Now()
+		event.Sequence = sequence
+		handler(event)
+	}
+}
+
+// mimeHeaders convert a received frame sorting the keys
+func mimeHeaders(data textproto.MIMEHeader) Headers {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var headers Headers
+	for _, k := range keys {
+		for _, v := range data[k] {
+			headers.Add(k, v)
+		}
+	}
+	return headers
+}
+
+// redact mask the values of Secret and Key headers
+func redact(headers Headers) Headers {
+	masked := make(Headers, len(headers))
+	for i, header := range headers {
+		if strings.EqualFold(header.Key, "Secret") || strings.EqualFold(header.Key, "Key") {
+			header.Value = redacted
+		}
+		masked[i] = header
+	}
+	return masked
+}
@@ -0,0 +1,109 @@
+package gami_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xytis/gami"
+	"github.com/xytis/gami/gamitest"
+)
+
+// syncBuffer is a bytes.Buffer safe for the concurrent writes of the client
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestRecordReplay(t *testing.T) {
+	srv := gamitest.NewServer()
+	defer srv.Close()
+
+	var recording syncBuffer
+	ami, err := gami.Connect(srv.Addr, "admin", "topsecret", gami.WithRecorder(&recording))
+	assert.NoError(t, err)
+	_, err = ami.Action("Ping", nil)
+	assert.NoError(t, err)
+	srv.Emit(gamitest.Event("Hangup", "Channel", "SIP/100-00000001", "Cause", "16"))
+	<-ami.Events
+
+	lines := strings.Split(strings.TrimSpace(recording.String()), "\n")
+	assert.Equal(t, 5, len(lines))
+	assert.False(t, strings.Contains(recording.String(), "topsecret"))
+
+	var login gami.Record
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &login))
+	assert.Equal(t, gami.RecordOut, login.Dir)
+	assert.Equal(t, "Login", login.Headers.Get("Action"))
+	assert.Equal(t, "********", login.Headers.Get("Secret"))
+
+	replayer, err := gami.NewReplayer(strings.NewReader(recording.String()), 0)
+	assert.NoError(t, err)
+	replay, err := gami.NewClient(replayer, "", "")
+	assert.NoError(t, err)
+	ev := <-replay.Events
+	assert.Equal(t, "Hangup", ev.ID)
+	assert.Equal(t, "SIP/100-00000001", ev.Params["Channel"])
+}
+
+func TestReplaySpeed(t *testing.T) {
+	recording := `{"time":"2015-01-02T15:04:05Z","dir":"in","headers":[{"key":"Event","value":"A"}]}
+{"time":"2015-01-02T15:04:05.1Z","dir":"out","headers":[{"key":"Action","value":"Ping"}]}
+{"time":"2015-01-02T15:04:05.2Z","dir":"in","headers":[{"key":"Event","value":"B"}]}
+`
+	var events []string
+	start := time.Now()
+	err := gami.Replay(strings.NewReader(recording), 10, func(ev *gami.AMIEvent) {
+		events = append(events, ev.ID)
+	})
+	assert.NoError(t, err)
+	elapsed := time.Since(start)
+
+	assert.Equal(t, []string{"A", "B"}, events)
+	assert.True(t, elapsed >= 20*time.Millisecond && elapsed < 200*time.Millisecond, elapsed)
+}
+
+func TestReplayerClose(t *testing.T) {
+	recording := `{"time":"2015-01-02T15:04:05Z","dir":"in","headers":[{"key":"Event","value":"A"}]}
+{"time":"2015-01-02T16:04:05Z","dir":"in","headers":[{"key":"Event","value":"B"}]}
+`
+	replayer, err := gami.NewReplayer(strings.NewReader(recording), 1)
+	assert.NoError(t, err)
+	data, err := replayer.ReadFrame()
+	assert.NoError(t, err)
+	assert.Equal(t, "A", data.Get("Event"))
+
+	// B is an hour later, Close interrupts the wait
+	read := make(chan error, 1)
+	go func() {
+		_, err := replayer.ReadFrame()
+		read <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, replayer.Close())
+	select {
+	case err := <-read:
+		assert.Equal(t, io.EOF, err)
+	case <-time.After(time.Second):
+		t.Fatal("ReadFrame not interrupted by Close")
+	}
+	_, err = replayer.ReadFrame()
+	assert.Equal(t, io.EOF, err)
+}
@@ -0,0 +1,171 @@
+package gami
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Raise when an action is blocked by WithRateLimit with a rate of 0
+var ErrActionBlocked = errors.New("Action blocked by rate limit")
+
+// Priority class of an action, actions waiting for the scheduler are sent
+// by priority then in the order they were made
+type Priority int
+
+// Priority classes
+const (
+	// PriorityBulk for campaign traffic, e.g. Originate
+	PriorityBulk Priority = -1
+	// PriorityNormal is the class of actions without a priority
+	PriorityNormal Priority = 0
+	// PriorityUrgent for actions that must not wait behind others, e.g. Hangup
+	PriorityUrgent Priority = 1
+)
+
+// scheduler holds actions back until their rate limit and the in-flight
+// limit allow them, enabled by WithRateLimit, WithMaxInFlight and
+// WithPriority
+type scheduler struct {
+	mu       sync.Mutex
+	limits   map[string]*bucket
+	priority map[string]Priority
+	max      int
+	inflight int
+	waiting  []*waiter
+	timer    *time.Timer
+}
+
+type waiter struct {
+	action   string
+	priority Priority
+	ready    chan struct{}
+}
+
+// bucket is a token bucket, rate tokens are added every second up to burst
+type bucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{
+		limits:   make(map[string]*bucket),
+		priority: make(map[string]Priority),
+	}
+}
+
+// actionKey of the limits and priorities, the action as written ignoring
+// case
+func actionKey(action string) string {
+	return strings.ToLower(strings.TrimSpace(action))
+}
+
+// acquire wait until the action can be sent, the caller must release once
+// the action is answered or failed
+func (s *scheduler) acquire(action string, done <-chan struct{}) error {
+	key := actionKey(action)
+	if b, ok := s.limits[key]; ok && b.rate <= 0 {
+		return ErrActionBlocked
+	}
+	w := &waiter{action: key, priority: s.priority[key], ready: make(chan struct{})}
+
+	s.mu.Lock()
+	ix := len(s.waiting)
+	for ix > 0 && s.waiting[ix-1].priority < w.priority {
+		ix--
+	}
+	s.waiting = append(s.waiting, nil)
+	copy(s.waiting[ix+1:], s.waiting[ix:])
+	s.waiting[ix] = w
+	s.dispatch()
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-done:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for i, other := range s.waiting {
+			if other == w {
+				s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+				return ErrClosed
+			}
+		}
+		// granted meanwhile
+		s.inflight--
+		s.dispatch()
+		return ErrClosed
+	}
+}
+
+// release the in-flight slot of an action
+func (s *scheduler) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inflight--
+	s.dispatch()
+}
+
+// dispatch let the waiting actions go while the limits allow it, rate
+// limited actions don't hold back the ones after them
+func (s *scheduler) dispatch() {
+	now := time.Now()
+	next := time.Duration(math.MaxInt64)
+	for i := 0; i < len(s.waiting); {
+		if s.max > 0 && s.inflight >= s.max {
+			break
+		}
+		w := s.waiting[i]
+		if b, ok := s.limits[w.action]; ok {
+			if wait := b.take(now); wait > 0 {
+				if wait < next {
+					next = wait
+				}
+				i++
+				continue
+			}
+		}
+		s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+		s.inflight++
+		close(w.ready)
+	}
+
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	if next != time.Duration(math.MaxInt64) {
+		s.timer = time.AfterFunc(next, func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.dispatch()
+		})
+	}
+}
+
+// take a token, or return how long until one is available
+func (b *bucket) take(now time.Time) time.Duration {
+	if !b.last.IsZero() {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// scheduling return the scheduler of the client, creating it
+func (client *AMIClient) scheduling() *scheduler {
+	if client.scheduler == nil {
+		client.scheduler = newScheduler()
+	}
+	return client.scheduler
+}
@@ -0,0 +1,113 @@
+package gami_test
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xytis/gami"
+	"github.com/xytis/gami/gamitest"
+)
+
+func TestRateLimit(t *testing.T) {
+	srv := gamitest.NewServer()
+	defer srv.Close()
+
+	ami, err := gami.Connect(srv.Addr, "admin", "secret", gami.WithRateLimit("ping", 20, 2))
+	assert.NoError(t, err)
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		_, err := ami.Action("Ping", nil)
+		assert.NoError(t, err)
+	}
+	// two from the burst, then one every 50ms
+	elapsed := time.Since(start)
+	assert.True(t, elapsed >= 90*time.Millisecond, elapsed)
+
+	// other actions are not limited
+	start = time.Now()
+	_, err = ami.Action("Events", gami.Params{"EventMask": "off"})
+	assert.NoError(t, err)
+	assert.True(t, time.Since(start) < 40*time.Millisecond)
+}
+
+func TestRateLimitBlock(t *testing.T) {
+	srv := gamitest.NewServer()
+	defer srv.Close()
+
+	ami, err := gami.Connect(srv.Addr, "admin", "secret",
+		gami.WithRateLimit("Originate", 0, 5), gami.WithRateLimit(" Command", -1, 1))
+	assert.NoError(t, err)
+	// the action is limited whatever the spaces around its name
+	for _, action := range []string{"Originate", "Originate ", "\toriginate"} {
+		_, err = ami.Action(action, nil)
+		assert.Equal(t, gami.ErrActionBlocked, err)
+	}
+	_, err = ami.Action("Command", gami.Params{"Command": "core restart now"})
+	assert.Equal(t, gami.ErrActionBlocked, err)
+	for _, action := range []string{"Originate", "Command"} {
+		_, ok := srv.WaitAction(action, 10*time.Millisecond)
+		assert.False(t, ok, action)
+	}
+
+	_, err = ami.Action("Ping", nil)
+	assert.NoError(t, err)
+}
+
+func TestPriority(t *testing.T) {
+	srv := gamitest.NewServer()
+	defer srv.Close()
+	srv.Delay("Originate", 50*time.Millisecond)
+
+	ami, err := gami.Connect(srv.Addr, "admin", "secret",
+		gami.WithMaxInFlight(1),
+		gami.WithPriority(gami.PriorityBulk, "Originate"),
+		gami.WithPriority(gami.PriorityUrgent, "Hangup"))
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	send := func(action, id string) {
+		defer wg.Done()
+		_, err := ami.Action(action, gami.Params{"ActionID": id})
+		assert.NoError(t, err)
+	}
+	wg.Add(1)
+	go send("Originate", "o1")
+	_, ok := srv.WaitAction("Originate", time.Second)
+	assert.True(t, ok)
+	for _, id := range []string{"o2", "o3"} {
+		wg.Add(1)
+		go send("Originate", id)
+	}
+	time.Sleep(10 * time.Millisecond)
+	wg.Add(1)
+	go send("Hangup", "h1")
+	wg.Wait()
+
+	var order []string
+	for _, action := range srv.Actions() {
+		order = append(order, action.ActionID())
+	}
+	assert.Equal(t, "h1", order[2], order)
+	rest := order[3:]
+	sort.Strings(rest)
+	assert.Equal(t, []string{"o2", "o3"}, rest)
+}
+
+func TestMaxInFlightTimeout(t *testing.T) {
+	srv := gamitest.NewServer()
+	defer srv.Close()
+	srv.Handle("Park", func(*gamitest.Action) []gami.Headers { return nil })
+
+	ami, err := gami.Connect(srv.Addr, "admin", "secret", gami.WithMaxInFlight(1))
+	assert.NoError(t, err)
+
+	// the slot of an action never answered is given back on timeout
+	_, err = ami.Action("Park", nil)
+	assert.Error(t, err)
+	_, err = ami.Action("Ping", nil)
+	assert.NoError(t, err)
+}
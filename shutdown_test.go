@@ -0,0 +1,108 @@
+package gami_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xytis/gami"
+	"github.com/xytis/gami/gamitest"
+)
+
+func TestShutdown(t *testing.T) {
+	srv := gamitest.NewServer()
+	defer srv.Close()
+	srv.Delay("Ping", 50*time.Millisecond)
+
+	ami, err := gami.Connect(srv.Addr, "admin", "secret")
+	assert.NoError(t, err)
+	ping, err := ami.AsyncAction("Ping", nil)
+	assert.NoError(t, err)
+
+	assert.NoError(t, ami.Shutdown(context.Background()))
+
+	// the pending action was drained before the Logoff
+	response, ok := <-ping
+	assert.True(t, ok)
+	assert.Equal(t, "Success", response.Status)
+	actions := srv.Actions()
+	assert.Equal(t, "Logoff", actions[len(actions)-1].Name)
+
+	_, ok = <-ami.Events
+	assert.False(t, ok)
+	_, ok = <-ami.Errors
+	assert.False(t, ok)
+
+	assert.NoError(t, ami.Shutdown(context.Background()))
+	_, err = ami.Action("Ping", nil)
+	assert.Equal(t, gami.ErrClosed, err)
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	srv := gamitest.NewServer()
+	defer srv.Close()
+	srv.Handle("Park", func(*gamitest.Action) []gami.Headers { return nil })
+
+	ami, err := gami.Connect(srv.Addr, "admin", "secret")
+	assert.NoError(t, err)
+	errc := make(chan error)
+	go func() {
+		_, err := ami.Action("Park", nil)
+		errc <- err
+	}()
+	_, ok := srv.WaitAction("Park", time.Second)
+	assert.True(t, ok)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	assert.NoError(t, ami.Shutdown(ctx))
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, gami.ErrClosed, <-errc)
+}
+
+func TestShutdownConnectionLost(t *testing.T) {
+	srv := gamitest.NewServer()
+	defer srv.Close()
+
+	ami, err := gami.Connect(srv.Addr, "admin", "secret")
+	assert.NoError(t, err)
+	srv.Emit(gamitest.Event("Hangup"))
+	time.Sleep(20 * time.Millisecond)
+	srv.DropConnections()
+	assert.Error(t, <-ami.Fatal)
+
+	// events read before the loss are still delivered
+	ev, ok := <-ami.Events
+	assert.True(t, ok)
+	assert.Equal(t, "Hangup", ev.ID)
+	_, ok = <-ami.Events
+	assert.False(t, ok)
+
+	assert.NoError(t, ami.Close())
+	assert.NoError(t, ami.Close())
+}
+
+func TestShutdownConcurrent(t *testing.T) {
+	srv := gamitest.NewServer()
+	defer srv.Close()
+
+	ami, err := gami.Connect(srv.Addr, "admin", "secret")
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ami.Shutdown(context.Background())
+		}()
+	}
+	srv.DropConnections()
+	wg.Wait()
+
+	_, ok := <-ami.Events
+	assert.False(t, ok)
+}
@@ -0,0 +1,314 @@
+package gami
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventStream is an event connection opened by ConnectSplit
+type EventStream struct {
+	// EventMask of the connection, e.g. "call,agent", empty turns every
+	// class on
+	EventMask string
+	// Filters added with the Filter action, e.g. "Event: Newchannel" or
+	// "!Channel: Local/" to drop the matching events
+	Filters []string
+}
+
+// ConnectSplit connect to AMI with one connection for the actions, logged
+// in with events off, and one connection per stream for the events. The
+// client has the same API as with Connect: actions are answered on the
+// action connection and the events of every stream are merged on Events,
+// streams should not overlap or events are received twice.
+//
+// A lost event connection is opened again in the background, the gap is
+// logged and counted as a reconnect. Losing the action connection closes
+// the event connections and is reported on Fatal. WithKeepalive pings the
+// action connection only, a half-open event connection is not detected.
+func ConnectSplit(address string, user string, secret string, streams []EventStream, options ...Option) (*AMIClient, error) {
+	client := newClient(address, options)
+	transport := &splitTransport{
+		address: address,
+		user:    user,
+		secret:  secret,
+		dial:    client.dial,
+		retry:   time.Second,
+		frames:  make(chan splitFrame),
+		done:    make(chan struct{}),
+		reconnected: func(stream int, err error) {
+			client.measure().Reconnected()
+			client.log().Warn("event connection reopened, events may be missing", "stream", stream, "error", err)
+		},
+	}
+	if err := transport.open(streams); err != nil {
+		return nil, err
+	}
+	if err := client.bind(transport); err != nil {
+		transport.Close()
+		return nil, err
+	}
+	client.run()
+	return client, client.login(user, secret)
+}
+
+// splitTransport is the Transport of ConnectSplit, actions are written to
+// the action connection and frames are read from every connection
+type splitTransport struct {
+	address string
+	user    string
+	secret  string
+	dial    func(network, address string) (net.Conn, error)
+	retry   time.Duration
+
+	// reconnected is called when an event connection was opened again
+	reconnected func(stream int, err error)
+
+	action *StreamTransport
+	banner string
+	frames chan splitFrame
+	done   chan struct{}
+	once   sync.Once
+
+	mu     sync.Mutex
+	events []*StreamTransport
+}
+
+type splitFrame struct {
+	frame textproto.MIMEHeader
+	err   error
+}
+
+// open dial the action connection then every event connection
+func (t *splitTransport) open(streams []EventStream) error {
+	var err error
+	if t.action, t.banner, err = t.connect(); err != nil {
+		return err
+	}
+	t.events = make([]*StreamTransport, len(streams))
+	early := make([][]textproto.MIMEHeader, len(streams))
+	for i, stream := range streams {
+		if t.events[i], early[i], err = t.openStream(stream); err != nil {
+			t.Close()
+			return err
+		}
+	}
+	go t.readActions()
+	for i, stream := range streams {
+		go t.readEvents(i, stream, t.events[i], early[i])
+	}
+	return nil
+}
+
+// connect dial a connection and check its banner
+func (t *splitTransport) connect() (*StreamTransport, string, error) {
+	conn, err := t.dial("tcp", t.address)
+	if err != nil {
+		return nil, "", err
+	}
+	st := NewStreamTransport(conn)
+	banner, err := st.ReadBanner()
+	if err == nil {
+		_, err = parseBanner(banner)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, "", err
+	}
+	return st, banner, nil
+}
+
+// openStream dial and set up an event connection, the events received
+// meanwhile are returned
+func (t *splitTransport) openStream(stream EventStream) (*StreamTransport, []textproto.MIMEHeader, error) {
+	st, _, err := t.connect()
+	if err != nil {
+		return nil, nil, err
+	}
+	mask := stream.EventMask
+	if mask == "" {
+		mask = "on"
+	}
+
+	// events are turned on once the filters are in place
+	type action struct {
+		name    string
+		headers Headers
+	}
+	actions := []action{{"Login", Headers{{"Username", t.user}, {"Secret", t.secret}, {"Events", "off"}}}}
+	for _, filter := range stream.Filters {
+		actions = append(actions, action{"Filter", Headers{{"Operation", "Add"}, {"Filter", filter}}})
+	}
+	actions = append(actions, action{"Events", Headers{{"EventMask", mask}}})
+
+	var early []textproto.MIMEHeader
+	for n, action := range actions {
+		for _, header := range action.headers {
+			if err := checkHeader(header.Key, header.Value); err != nil {
+				st.Close()
+				return nil, nil, err
+			}
+		}
+		actionID := "gami-split-" + strconv.Itoa(n)
+		action.headers.Add("ActionID", actionID)
+		events, err := exchange(st, buildFrame(action.name, action.headers), actionID)
+		early = append(early, events...)
+		if err != nil {
+			st.Close()
+			return nil, nil, err
+		}
+	}
+	return st, early, nil
+}
+
+// exchange write an action and read up to its response, returning the
+// events read meanwhile
+func exchange(st *StreamTransport, frame []byte, actionID string) ([]textproto.MIMEHeader, error) {
+	if err := st.WriteFrame(frame); err != nil {
+		return nil, err
+	}
+	var events []textproto.MIMEHeader
+	for {
+		data, err := st.ReadFrame()
+		if err != nil {
+			return events, err
+		}
+		if data.Get("Event") != "" {
+			events = append(events, data)
+			continue
+		}
+		if data.Get("ActionID") != actionID {
+			continue
+		}
+		if data.Get("Response") == "Error" {
+			return events, errors.New(data.Get("Message"))
+		}
+		return events, nil
+	}
+}
+
+// send pass a frame to ReadFrame, false once the transport is closed
+func (t *splitTransport) send(frame splitFrame) bool {
+	select {
+	case t.frames <- frame:
+		return true
+	case <-t.done:
+		return false
+	}
+}
+
+func (t *splitTransport) readActions() {
+	for {
+		data, err := t.action.ReadFrame()
+		if !t.send(splitFrame{data, err}) || err != nil {
+			return
+		}
+	}
+}
+
+// readEvents forward the events of a connection, opening it again when lost
+func (t *splitTransport) readEvents(i int, stream EventStream, st *StreamTransport, early []textproto.MIMEHeader) {
+	for {
+		for _, data := range early {
+			if !t.send(splitFrame{frame: data}) {
+				return
+			}
+		}
+		var err error
+		for {
+			var data textproto.MIMEHeader
+			if data, err = st.ReadFrame(); err != nil {
+				break
+			}
+			if data.Get("Event") != "" && !t.send(splitFrame{frame: data}) {
+				return
+			}
+		}
+		st.Close()
+
+		for {
+			select {
+			case <-t.done:
+				return
+			case <-time.After(t.retry):
+			}
+			var openErr error
+			if st, early, openErr = t.openStream(stream); openErr == nil {
+				break
+			}
+		}
+		t.mu.Lock()
+		select {
+		case <-t.done:
+			t.mu.Unlock()
+			st.Close()
+			return
+		default:
+		}
+		t.events[i] = st
+		t.mu.Unlock()
+		t.reconnected(i, err)
+	}
+}
+
+// SetWriteTimeout set the write deadline of the action connection
+func (t *splitTransport) SetWriteTimeout(timeout time.Duration) {
+	t.action.SetWriteTimeout(timeout)
+}
+
+// ReadBanner return the banner of the action connection
+func (t *splitTransport) ReadBanner() (string, error) {
+	return t.banner, nil
+}
+
+// ReadFrame implements Transport
+func (t *splitTransport) ReadFrame() (textproto.MIMEHeader, error) {
+	select {
+	case frame := <-t.frames:
+		return frame.frame, frame.err
+	case <-t.done:
+		return nil, io.EOF
+	}
+}
+
+// WriteFrame implements Transport, logins are sent with events off
+func (t *splitTransport) WriteFrame(frame []byte) error {
+	if !bytes.Contains(bytes.ToLower(frame), []byte("action: login\r\n")) {
+		return t.action.WriteFrame(frame)
+	}
+	var buf []byte
+	for _, action := range splitActions(frame) {
+		name := action.Get("Action")
+		action.Del("Action")
+		if strings.EqualFold(name, "Login") {
+			action.Set("Events", "off")
+		}
+		buf = append(buf, buildFrame(name, action)...)
+	}
+	return t.action.WriteFrame(buf)
+}
+
+// Close implements Transport, every connection is closed
+func (t *splitTransport) Close() error {
+	var err error
+	t.once.Do(func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		close(t.done)
+		if t.action != nil {
+			err = t.action.Close()
+		}
+		for _, st := range t.events {
+			if st != nil {
+				st.Close()
+			}
+		}
+	})
+	return err
+}
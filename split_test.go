@@ -0,0 +1,120 @@
+package gami_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xytis/gami"
+	"github.com/xytis/gami/gamitest"
+)
+
+// logins return the Login actions received with the given Events header
+func logins(srv *gamitest.Server, events string) []*gamitest.Action {
+	var found []*gamitest.Action
+	for _, action := range srv.Actions() {
+		if action.Name == "Login" && action.Headers.Get("Events") == events {
+			found = append(found, action)
+		}
+	}
+	return found
+}
+
+func TestConnectSplit(t *testing.T) {
+	srv := gamitest.NewServer()
+	defer srv.Close()
+
+	ami, err := gami.ConnectSplit(srv.Addr, "admin", "secret", []gami.EventStream{
+		{EventMask: "call", Filters: []string{"!Channel: Local/"}},
+		{EventMask: "system"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "5.0.2", ami.Version())
+	assert.Equal(t, 3, len(logins(srv, "off")))
+
+	response, err := ami.Action("Ping", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "Success", response.Status)
+
+	srv.Emit(gamitest.Event("Newchannel", "Privilege", "call,all", "Channel", "Local/100@default-00000001;1"))
+	srv.Emit(gamitest.Event("Newchannel", "Privilege", "call,all", "Channel", "SIP/100-00000001"))
+	srv.Emit(gamitest.Event("AgentLogin", "Privilege", "agent,all"))
+	srv.Emit(gamitest.Event("Reload", "Privilege", "system,all"))
+
+	var received []string
+	for len(received) < 2 {
+		select {
+		case ev := <-ami.Events:
+			received = append(received, ev.ID+" "+ev.Params["Channel"])
+		case <-time.After(time.Second):
+			t.Fatal("events not received:", received)
+		}
+	}
+	select {
+	case ev := <-ami.Events:
+		t.Fatal("unexpected event:", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.True(t, received[0] == "Newchannel SIP/100-00000001" || received[1] == "Newchannel SIP/100-00000001", received)
+	assert.True(t, received[0] == "Reload " || received[1] == "Reload ", received)
+}
+
+func TestConnectSplitReconnect(t *testing.T) {
+	srv := gamitest.NewServer()
+	defer srv.Close()
+
+	values := &counter{mu: new(sync.Mutex), values: make(map[string]float64), name: "reconnects"}
+	ami, err := gami.ConnectSplit(srv.Addr, "admin", "secret", []gami.EventStream{{}},
+		gami.WithMetrics(&gami.Collectors{Reconnects: values}))
+	assert.NoError(t, err)
+
+	// drop the event connection only
+	for _, action := range srv.Actions() {
+		if action.Name == "Events" {
+			action.Conn.Close()
+		}
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for values.get("reconnects") == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, float64(1), values.get("reconnects"))
+
+	srv.Emit(gamitest.Event("Hangup"))
+	select {
+	case ev := <-ami.Events:
+		assert.Equal(t, "Hangup", ev.ID)
+	case <-time.After(time.Second):
+		t.Fatal("event not received after reconnect")
+	}
+	select {
+	case err := <-ami.Fatal:
+		t.Fatal("event connection loss reported on Fatal:", err)
+	default:
+	}
+	_, err = ami.Action("Ping", nil)
+	assert.NoError(t, err)
+}
+
+func TestConnectSplitActionLost(t *testing.T) {
+	srv := gamitest.NewServer()
+	defer srv.Close()
+
+	ami, err := gami.ConnectSplit(srv.Addr, "admin", "secret", []gami.EventStream{{}})
+	assert.NoError(t, err)
+	_, err = ami.Action("Ping", gami.Params{"ActionID": "ping"})
+	assert.NoError(t, err)
+	ping, _ := srv.WaitAction("Ping", time.Second)
+	ping.Conn.Close()
+
+	assert.Error(t, <-ami.Fatal)
+	_, ok := <-ami.Events
+	assert.False(t, ok)
+	// the event connections are closed too
+	deadline := time.Now().Add(time.Second)
+	for len(srv.Conns()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 0, len(srv.Conns()))
+}
@@ -0,0 +1,137 @@
+package gami
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Raise on Fatal when the connection is lost after Asterisk announced a restart
+var ErrAsteriskRestart = errors.New("Asterisk is restarting")
+
+// Raise on Fatal when the connection is lost after Asterisk announced a shutdown
+var ErrAsteriskShutdown = errors.New("Asterisk shut down")
+
+// State of a client
+type State int
+
+// States of a client, in the order they are normally reached
+const (
+	// StateConnecting until the login is accepted
+	StateConnecting State = iota
+	// StateAuthenticated once logged in, Asterisk may still be booting
+	StateAuthenticated
+	// StateReady once the FullyBooted event is received
+	StateReady
+	// StateShuttingDown when Asterisk announced a Shutdown or Shutdown was called
+	StateShuttingDown
+	// StateClosed once the connection is closed or lost
+	StateClosed
+)
+
+var stateNames = []string{"Connecting", "Authenticated", "Ready", "ShuttingDown", "Closed"}
+
+func (s State) String() string {
+	if s < 0 || int(s) >= len(stateNames) {
+		return "State(" + strconv.Itoa(int(s)) + ")"
+	}
+	return stateNames[s]
+}
+
+// Transition of the client from one state to another
+type Transition struct {
+	Time time.Time
+	From State
+	To   State
+	// Reason of the transition, e.g. the event received
+	Reason string
+}
+
+// State of the client
+func (client *AMIClient) State() State {
+	client.stateMu.Lock()
+	defer client.stateMu.Unlock()
+	return client.state
+}
+
+// WaitReady wait until Asterisk is fully booted. Asterisk sends FullyBooted
+// on login when it is already booted, the system class must be in the read
+// privileges of the user for it to be received. It fails with ErrClosed
+// when the client is shutting down or closed.
+func (client *AMIClient) WaitReady(ctx context.Context) error {
+	select {
+	case <-client.ready:
+		if client.State() == StateReady {
+			return nil
+		}
+		return ErrClosed
+	case <-client.done:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// setState move the client to a new state, a closed client stays closed
+// and the transitions are reported to the observer set by WithStateObserver
+func (client *AMIClient) setState(to State, reason string) {
+	client.stateMu.Lock()
+	from := client.state
+	if from == to || from == StateClosed {
+		client.stateMu.Unlock()
+		return
+	}
+	client.state = to
+	if to >= StateReady && client.ready != nil {
+		select {
+		case <-client.ready:
+		default:
+			close(client.ready)
+		}
+	}
+	client.stateMu.Unlock()
+
+	client.log().Info("state changed", "from", from.String(), "to", to.String(), "reason", reason)
+	if client.stateObserver != nil {
+		client.stateObserver(Transition{Time: time.Now(), From: from, To: to, Reason: reason})
+	}
+}
+
+// authenticated move the client to StateAuthenticated after the login,
+// FullyBooted may have been received already
+func (client *AMIClient) authenticated() {
+	client.setState(StateAuthenticated, "logged in")
+	client.stateMu.Lock()
+	booted := client.booted
+	client.stateMu.Unlock()
+	if booted {
+		client.setState(StateReady, "FullyBooted")
+	}
+}
+
+// watch the events changing the state of the client, called by main
+func (client *AMIClient) watch(event *AMIEvent) {
+	switch event.ID {
+	case "FullyBooted":
+		client.stateMu.Lock()
+		client.booted = true
+		state := client.state
+		client.stateMu.Unlock()
+		if state == StateAuthenticated {
+			client.setState(StateReady, "FullyBooted")
+		}
+	case "Shutdown":
+		reason := ErrAsteriskShutdown
+		if strings.EqualFold(event.Params["Restart"], "true") {
+			reason = ErrAsteriskRestart
+		}
+		client.mu.Lock()
+		if client.dead == nil {
+			client.dead = reason
+		}
+		client.mu.Unlock()
+		client.setState(StateShuttingDown, reason.Error()+" ("+event.Params["Shutdown"]+")")
+	}
+}
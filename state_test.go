@@ -0,0 +1,95 @@
+package gami_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xytis/gami"
+	"github.com/xytis/gami/gamitest"
+)
+
+func TestWaitReady(t *testing.T) {
+	srv := gamitest.NewServer()
+	defer srv.Close()
+
+	var mu sync.Mutex
+	var transitions []string
+	observer := func(tr gami.Transition) {
+		mu.Lock()
+		defer mu.Unlock()
+		transitions = append(transitions, tr.From.String()+">"+tr.To.String())
+	}
+	ami, err := gami.Connect(srv.Addr, "admin", "secret", gami.WithStateObserver(observer))
+	assert.NoError(t, err)
+	assert.Equal(t, gami.StateAuthenticated, ami.State())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, ami.WaitReady(ctx))
+
+	srv.Emit(gamitest.Event("FullyBooted", "Status", "Fully Booted"))
+	assert.NoError(t, ami.WaitReady(context.Background()))
+	assert.Equal(t, gami.StateReady, ami.State())
+	// the event is still delivered
+	assert.Equal(t, "FullyBooted", (<-ami.Events).ID)
+
+	assert.NoError(t, ami.Shutdown(context.Background()))
+	assert.Equal(t, gami.StateClosed, ami.State())
+	assert.Equal(t, gami.ErrClosed, ami.WaitReady(context.Background()))
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{
+		"Connecting>Authenticated",
+		"Authenticated>Ready",
+		"Ready>ShuttingDown",
+		"ShuttingDown>Closed",
+	}, transitions)
+}
+
+func TestBootedBeforeLogin(t *testing.T) {
+	srv := gamitest.NewServer()
+	defer srv.Close()
+	// Asterisk sends FullyBooted right after accepting the login
+	srv.Handle("Login", func(action *gamitest.Action) []gami.Headers {
+		return []gami.Headers{
+			gamitest.Response("Success", "Message", "Authentication accepted"),
+			gamitest.Event("FullyBooted", "Status", "Fully Booted"),
+		}
+	})
+
+	ami, err := gami.Connect(srv.Addr, "admin", "secret")
+	assert.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, ami.WaitReady(ctx))
+}
+
+func TestAsteriskShutdown(t *testing.T) {
+	for _, tc := range []struct {
+		restart string
+		err     error
+	}{
+		{"True", gami.ErrAsteriskRestart},
+		{"False", gami.ErrAsteriskShutdown},
+	} {
+		srv := gamitest.NewServer()
+		ami, err := gami.Connect(srv.Addr, "admin", "secret")
+		assert.NoError(t, err)
+
+		srv.Emit(gamitest.Event("Shutdown", "Shutdown", "Cleanly", "Restart", tc.restart))
+		assert.Equal(t, "Shutdown", (<-ami.Events).ID)
+		assert.Equal(t, gami.StateShuttingDown, ami.State())
+		assert.Equal(t, gami.ErrClosed, ami.WaitReady(context.Background()))
+
+		srv.DropConnections()
+		assert.Equal(t, tc.err, <-ami.Fatal)
+		_, ok := <-ami.Events
+		assert.False(t, ok)
+		assert.Equal(t, gami.StateClosed, ami.State())
+		srv.Close()
+	}
+}
@@ -0,0 +1,85 @@
+package gami
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/textproto"
+	"sync"
+	"time"
+)
+
+// Trace is a frame seen on the wire, Secret and Key values are masked
+type Trace struct {
+	Time time.Time
+	// Dir is RecordIn or RecordOut
+	Dir      string
+	ActionID string
+	Headers  Headers
+}
+
+// String format the trace as the time, direction and ActionID followed by
+// the frame as sent on the wire
+func (trace Trace) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s ActionID=%s\n", trace.Time.Format(time.RFC3339Nano), trace.Dir, trace.ActionID)
+	for _, header := range trace.Headers {
+		fmt.Fprintf(&buf, "%s: %s\n", header.Key, header.Value)
+	}
+	return buf.String()
+}
+
+// traceWriter write traces to w one after the other
+func traceWriter(w io.Writer) func(Trace) {
+	var mu sync.Mutex
+	return func(trace Trace) {
+		mu.Lock()
+		defer mu.Unlock()
+		io.WriteString(w, trace.String()+"\n")
+	}
+}
+
+// traceHook build the hook calling tracer
+func traceHook(tracer func(Trace)) func(dir string, headers Headers) {
+	return func(dir string, headers Headers) {
+		tracer(Trace{
+			Time:     time.Now(),
+			Dir:      dir,
+			ActionID: headers.Get("ActionID"),
+			Headers:  redact(headers),
+		})
+	}
+}
+
+// hookConn pass the frames going through the client connection to hook,
+// secrets are not masked
+type hookConn struct {
+	MIMEReadWriteCloser
+	hook func(dir string, headers Headers)
+}
+
+func (conn *hookConn) ReadMIMEHeader() (textproto.MIMEHeader, error) {
+	data, err := conn.MIMEReadWriteCloser.ReadMIMEHeader()
+	if err == nil {
+		conn.hook(RecordIn, mimeHeaders(data))
+	}
+	return data, err
+}
+
+func (conn *hookConn) PrintfLine(format string, args ...interface{}) error {
+	line := fmt.Sprintf(format, args...)
+	for _, action := range splitActions([]byte(line + "\r\n")) {
+		conn.hook(RecordOut, action)
+	}
+	return conn.MIMEReadWriteCloser.PrintfLine("%s", line)
+}
+
+func (conn *hookConn) WriteFrame(frame []byte) error {
+	for _, action := range splitActions(frame) {
+		conn.hook(RecordOut, action)
+	}
+	if fw, ok := conn.MIMEReadWriteCloser.(FrameWriter); ok {
+		return fw.WriteFrame(frame)
+	}
+	return conn.MIMEReadWriteCloser.PrintfLine("%s", frame[:len(frame)-2])
+}
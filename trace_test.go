@@ -0,0 +1,55 @@
+package gami_test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xytis/gami"
+	"github.com/xytis/gami/gamitest"
+)
+
+func TestTracer(t *testing.T) {
+	srv := gamitest.NewServer()
+	defer srv.Close()
+
+	var mu sync.Mutex
+	var traces []gami.Trace
+	tracer := func(trace gami.Trace) {
+		mu.Lock()
+		defer mu.Unlock()
+		traces = append(traces, trace)
+	}
+	ami, err := gami.Connect(srv.Addr, "admin", "topsecret", gami.WithTracer(tracer))
+	assert.NoError(t, err)
+	_, err = ami.Action("Ping", gami.Params{"ActionID": "ping-1"})
+	assert.NoError(t, err)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 4, len(traces))
+	assert.Equal(t, gami.RecordOut, traces[0].Dir)
+	assert.Equal(t, "r0", traces[0].ActionID)
+	assert.Equal(t, "********", traces[0].Headers.Get("Secret"))
+	assert.Equal(t, gami.RecordIn, traces[1].Dir)
+	assert.Equal(t, "r0", traces[1].ActionID)
+	assert.Equal(t, "ping-1", traces[2].ActionID)
+	assert.Equal(t, "Pong", traces[3].Headers.Get("Ping"))
+	assert.False(t, traces[0].Time.IsZero())
+}
+
+func TestTraceWriter(t *testing.T) {
+	srv := gamitest.NewServer()
+	defer srv.Close()
+
+	var out syncBuffer
+	_, err := gami.Connect(srv.Addr, "admin", "topsecret", gami.WithTraceWriter(&out))
+	assert.NoError(t, err)
+
+	trace := out.String()
+	assert.True(t, strings.Contains(trace, " out ActionID=r0\nAction: Login\n"), trace)
+	assert.True(t, strings.Contains(trace, "Secret: ********\n"), trace)
+	assert.True(t, strings.Contains(trace, " in ActionID=r0\n"), trace)
+	assert.False(t, strings.Contains(trace, "topsecret"), trace)
+}
@@ -0,0 +1,105 @@
+package gami
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// Transport moves whole AMI frames between the client and the server.
+// NewStreamTransport covers any net.Conn or io.ReadWriteCloser, other
+// transports only need to implement these three methods.
+type Transport interface {
+	// ReadFrame block until the next response or event is received
+	ReadFrame() (textproto.MIMEHeader, error)
+	// WriteFrame send one or more complete actions
+	WriteFrame(frame []byte) error
+	Close() error
+}
+
+// StreamTransport reads and writes AMI frames over a stream connection,
+// every frame is sent with a single Write
+type StreamTransport struct {
+	reader  *textproto.Reader
+	rwc     io.ReadWriteCloser
+	timeout time.Duration
+}
+
+// NewStreamTransport wrap an established connection, NewClient reads the
+// banner from it
+func NewStreamTransport(rwc io.ReadWriteCloser) *StreamTransport {
+	return &StreamTransport{
+		reader: textproto.NewReader(bufio.NewReader(rwc)),
+		rwc:    rwc,
+	}
+}
+
+// SetWriteTimeout set the deadline for each write, only applies to net.Conn
+func (t *StreamTransport) SetWriteTimeout(timeout time.Duration) {
+	t.timeout = timeout
+}
+
+// ReadBanner read the greeting line sent by Asterisk on connect
+func (t *StreamTransport) ReadBanner() (string, error) {
+	return t.reader.ReadLine()
+}
+
+// ReadFrame implements Transport
+func (t *StreamTransport) ReadFrame() (textproto.MIMEHeader, error) {
+	return t.reader.ReadMIMEHeader()
+}
+
+// ReadMIMEHeader implements MIMEReadWriteCloser
+func (t *StreamTransport) ReadMIMEHeader() (textproto.MIMEHeader, error) {
+	return t.reader.ReadMIMEHeader()
+}
+
+// WriteFrame implements Transport
+func (t *StreamTransport) WriteFrame(frame []byte) error {
+	if nc, ok := t.rwc.(net.Conn); ok && t.timeout > 0 {
+		if err := nc.SetWriteDeadline(time.Now().Add(t.timeout)); err != nil {
+			return err
+		}
+	}
+	_, err := t.rwc.Write(frame)
+	return err
+}
+
+// PrintfLine implements MIMEReadWriteCloser
+func (t *StreamTransport) PrintfLine(format string, args ...interface{}) error {
+	return t.WriteFrame([]byte(fmt.Sprintf(format, args...) + "\r\n"))
+}
+
+// Close implements Transport
+func (t *StreamTransport) Close() error {
+	return t.rwc.Close()
+}
+
+// transportConn adapt a Transport to the MIMEReadWriteCloser used by the client
+type transportConn struct {
+	Transport
+}
+
+func (conn transportConn) ReadMIMEHeader() (textproto.MIMEHeader, error) {
+	return conn.ReadFrame()
+}
+
+func (conn transportConn) PrintfLine(format string, args ...interface{}) error {
+	return conn.WriteFrame([]byte(fmt.Sprintf(format, args...) + "\r\n"))
+}
+
+// parseBanner check the greeting is AMI and extract the protocol version,
+// e.g. "Asterisk Call Manager/5.0.2" gives "5.0.2"
+func parseBanner(banner string) (string, error) {
+	const prefix = "Asterisk Call Manager"
+	ix := strings.Index(banner, prefix)
+	if ix < 0 {
+		return "", ErrNotAMI
+	}
+	version := strings.TrimSpace(banner[ix+len(prefix):])
+	return strings.TrimPrefix(version, "/"), nil
+}
@@ -0,0 +1,108 @@
+package gami
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/textproto"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// serveLogin play the Asterisk side of the handshake on conn
+func serveLogin(t *testing.T, conn net.Conn, banner string) {
+	server := textproto.NewConn(conn)
+	if err := server.PrintfLine("%s", banner); err != nil {
+		return
+	}
+	login, err := server.ReadMIMEHeader()
+	if err != nil {
+		return
+	}
+	assert.Equal(t, "Login", login.Get("Action"))
+	assert.Equal(t, "admin", login.Get("Username"))
+	server.PrintfLine("Response: Success\r\nActionID: %s\r\nMessage: Authentication accepted\r\n", login.Get("ActionID"))
+}
+
+func TestParseBanner(t *testing.T) {
+	version, err := parseBanner("Asterisk Call Manager/5.0.2")
+	assert.NoError(t, err)
+	assert.Equal(t, "5.0.2", version)
+
+	version, err = parseBanner("Asterisk Call Manager")
+	assert.NoError(t, err)
+	assert.Equal(t, "", version)
+
+	_, err = parseBanner("SSH-2.0-OpenSSH_6.6")
+	assert.Equal(t, ErrNotAMI, err)
+}
+
+func TestNewClient(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	go serveLogin(t, remote, "Asterisk Call Manager/5.0.2")
+
+	client, err := NewClient(NewStreamTransport(local), "admin", "secret")
+	assert.NoError(t, err)
+	assert.Equal(t, "5.0.2", client.Version())
+}
+
+func TestNewClientNotAMI(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	go serveLogin(t, remote, "SSH-2.0-OpenSSH_6.6")
+
+	_, err := NewClient(NewStreamTransport(local), "admin", "secret")
+	assert.Equal(t, ErrNotAMI, err)
+}
+
+func TestConnectWithDialer(t *testing.T) {
+	var dialed string
+	dialer := func(network, address string) (net.Conn, error) {
+		dialed = network + "://" + address
+		local, remote := net.Pipe()
+		go serveLogin(t, remote, "Asterisk Call Manager/2.10.4")
+		return local, nil
+	}
+
+	client, err := Connect("pbx:5038", "admin", "secret", WithDialer(dialer))
+	assert.NoError(t, err)
+	assert.Equal(t, "tcp://pbx:5038", dialed)
+	assert.Equal(t, "2.10.4", client.Version())
+}
+
+// ChanTransport is a Transport without banner nor textproto
+type ChanTransport struct {
+	in  chan textproto.MIMEHeader
+	out chan []byte
+}
+
+func (c *ChanTransport) ReadFrame() (textproto.MIMEHeader, error) {
+	return <-c.in, nil
+}
+
+func (c *ChanTransport) WriteFrame(frame []byte) error {
+	c.out <- frame
+	return nil
+}
+
+func (c *ChanTransport) Close() error {
+	return nil
+}
+
+func TestCustomTransport(t *testing.T) {
+	transport := &ChanTransport{in: make(chan textproto.MIMEHeader), out: make(chan []byte)}
+	go func() {
+		frame := <-transport.out
+		action, err := textproto.NewReader(bufio.NewReader(bytes.NewReader(frame))).ReadMIMEHeader()
+		assert.NoError(t, err)
+		transport.in <- textproto.MIMEHeader{"Response": {"Success"}, "Actionid": {action.Get("ActionID")}, "Ping": {"Pong"}}
+	}()
+
+	client, err := NewClient(transport, "", "")
+	assert.NoError(t, err)
+	response, err := client.Action("Ping", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "Pong", response.Params["Ping"])
+}